@@ -35,9 +35,10 @@ type Adapter interface {
 }
 
 // NewSqlmockAdapter returns a mock gorm.DB and an Adapter backed by
-// go-sqlmock
+// go-sqlmock. INSERT and UPDATE statements are matched in their canonical
+// form, so that their columns may be in any order.
 func NewSqlmockAdapter(dialect string, args ...interface{}) (*gorm.DB, Adapter, error) {
-	gormDb, err := gorm.Open("sqlmock", "mock_gorm_dsn")
+	gormDb, err := gorm.Open("sqlmock", openCanonicalDB())
 
 	if err != nil {
 		return nil, nil, err
@@ -46,6 +47,12 @@ func NewSqlmockAdapter(dialect string, args ...interface{}) (*gorm.DB, Adapter,
 	return gormDb, &SqlmockAdapter{db: db, mocker: mock}, nil
 }
 
+// openCanonicalDB opens a pool of connections to the go-sqlmock database that
+// match statements in their canonical form, as expectations are recorded
+func openCanonicalDB() *sql.DB {
+	return sql.OpenDB(canonicalConnector{driver: db.Driver(), dsn: "mock_gorm_dsn"})
+}
+
 // SqlmockAdapter implemenets the Adapter interface using go-sqlmock
 // it is the default Adapter
 type SqlmockAdapter struct {
@@ -214,3 +221,30 @@ func (c *SqlmockTxCommitter) WillFail(err error) TxCommitter {
 	expectation := c.commit.WillReturnError(err)
 	return &SqlmockTxCommitter{commit: expectation}
 }
+
+// the following implement expectations for adapters that ignore them, e.g.
+// RecordReplayAdapter while recording, and FakeAdapter
+
+type ignoredQueryer struct{}
+
+func (q *ignoredQueryer) Returns(rows interface{}) Queryer  { return q }
+func (q *ignoredQueryer) Errors(err error) Queryer          { return q }
+func (q *ignoredQueryer) Args(args ...driver.Value) Queryer { return q }
+
+type ignoredExecer struct{}
+
+func (e *ignoredExecer) WillSucceed(lastInsertID, rowsAffected int64) Execer { return e }
+func (e *ignoredExecer) WillFail(err error) Execer                           { return e }
+func (e *ignoredExecer) Args(args ...driver.Value) Execer                    { return e }
+
+type ignoredTxBeginner struct{}
+
+func (b *ignoredTxBeginner) WillFail(err error) TxBeginner { return b }
+
+type ignoredTxCommitter struct{}
+
+func (c *ignoredTxCommitter) WillFail(err error) TxCommitter { return c }
+
+type ignoredTxRollback struct{}
+
+func (r *ignoredTxRollback) WillFail(err error) TxRollback { return r }
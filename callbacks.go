@@ -1,8 +1,9 @@
 package gormexpect
 
 import (
-	"bytes"
+	"database/sql/driver"
 	"fmt"
+	"reflect"
 	"regexp"
 
 	"github.com/jinzhu/gorm"
@@ -13,11 +14,23 @@ type Recorder struct {
 	blankColumns []string
 	stmts        []Stmt
 	preload      []Preload // store it on Recorder
+	recorded     []Statement
 }
 
 // Record records a Stmt for use when SQL is finally executed
 // By default, it escapes with regexp.EscapeMeta
 func (r *Recorder) Record(stmt Stmt, shouldEscape bool) {
+	if stmt.raw == "" {
+		stmt.raw = stmt.sql
+	}
+
+	r.recorded = append(r.recorded, Statement{
+		Kind:    stmt.kind,
+		SQL:     stmt.raw,
+		Args:    stmt.args,
+		Preload: stmt.preload,
+	})
+
 	if shouldEscape {
 		stmt.sql = regexp.QuoteMeta(stmt.sql)
 	}
@@ -49,12 +62,42 @@ func (r *Recorder) IsEmpty() bool {
 	return len(r.stmts) == 0
 }
 
+// Recorded returns every statement recorded so far, including those that
+// have already been turned into expectations
+func (r *Recorder) Recorded() []Statement {
+	recorded := make([]Statement, len(r.recorded))
+	copy(recorded, r.recorded)
+
+	return recorded
+}
+
 // Stmt represents a sql statement. It can be an Exec, Query, or QueryRow
 type Stmt struct {
 	kind    string // can be Query, Exec, QueryRow
 	preload string // contains schema if it is a preload query
 	sql     string
+	raw     string // sql as executed, before it was escaped or rewritten
 	args    []interface{}
+	order   []int // order of args in the canonical statement, see canonicalStmt
+}
+
+// Statement is the exported view of a recorded Stmt
+type Statement struct {
+	Kind    string // "query" or "exec"
+	SQL     string
+	Args    []interface{}
+	Preload string // the preload schema (e.g. "Orders.Items"), if any
+}
+
+// String formats the statement the way Dump prints it
+func (s Statement) String() string {
+	str := fmt.Sprintf("%s: %s %v", s.Kind, s.SQL, s.Args)
+
+	if s.Preload != "" {
+		str += fmt.Sprintf(" (preload %s)", s.Preload)
+	}
+
+	return str
 }
 
 func recordExecCallback(scope *gorm.Scope) {
@@ -69,57 +112,145 @@ func recordExecCallback(scope *gorm.Scope) {
 		return
 	}
 
-	stmt := Stmt{
+	stmt := canonicalStmt(Stmt{
 		kind: "exec",
 		sql:  scope.SQL,
 		args: scope.SQLVars,
-	}
+	})
 
 	if blankColumns, ok := scope.InstanceGet("gorm:blank_columns_with_default_value"); ok {
 		// use this hack to retrieve our columns later
 		recorder.blankColumns = blankColumns.([]string)
 	}
 
-	strs, cols := parseUpdateColumns(stmt.sql)
+	if recordWithMatcher(scope, recorder, stmt) {
+		return
+	}
 
-	if len(cols) > 1 {
-		// we generate a better regex
-		newRegexp := bytes.NewBufferString("")
-		newRegexp.WriteString(strs[0])
-		newRegexp.WriteString("(")
+	recorder.Record(stmt, true)
+}
+
+// noopRowsCallback runs before gorm:query and gorm:row_query. The noop
+// database has no data of its own, so it is told to return rows built from the
+// model passed to Returns, or from the model being queried. This keeps gorm
+// behaving as it would with real results, e.g. when preloading.
+func noopRowsCallback(scope *gorm.Scope) {
+	c, ok := scope.Get("gorm_expect:noop")
+
+	if !ok {
+		return
+	}
 
-		for i, col := range cols {
-			if i == 0 {
-				newRegexp.WriteString(fmt.Sprintf("%s,?|", col))
-				continue
-			}
+	noop := c.(NoopController)
+	schema := matchPreload(scope)
+	ret, _ := scope.Get("gorm_expect:ret")
 
-			if i == len(cols)-1 {
-				newRegexp.WriteString(fmt.Sprintf(" %s,?)*", col))
-				continue
-			}
+	// rows are built using scopes without a DB, which can only get model
+	// structs that gorm has already cached
+	if scope.Value != nil {
+		scope.GetModelStruct()
+	}
 
-			newRegexp.WriteString(fmt.Sprintf(" %s,?|", col))
+	if ret != nil {
+		scope.New(ret).GetModelStruct()
+	}
+
+	if ret != nil && schema != "" {
+		if field, _, values := getPreloadValues(reflect.ValueOf(ret), schema); field != nil {
+			columns, rows, err := getRelationValues(field, values)
+			returnRows(scope, noop, columns, rows, err)
+			return
 		}
+	}
 
-		newRegexp.WriteString(strs[1])
+	if ret != nil && schema == "" {
+		retType := indirect(reflect.ValueOf(ret)).Type()
+
+		if retType.Kind() != reflect.Struct && retType.Kind() != reflect.Slice {
+			columns, rows, err := getDestValues(ret)
+			returnRows(scope, noop, columns, rows, err)
+			return
+		}
 
-		stmt.sql = newRegexp.String()
+		// Scan queries the model, but scans into a destination of its own
+		dest := scope.Value
 
-		recorder.Record(stmt, false)
+		if destination, ok := scope.Get("gorm:query_destination"); ok {
+			dest = destination
+		}
+
+		if dest != nil && getElemType(retType) == getElemType(reflect.TypeOf(dest)) {
+			columns, rows, err := getDestValues(ret)
+			returnRows(scope, noop, columns, rows, err)
+			return
+		}
+	}
+
+	columns, rows, err := getModelValues(scope)
+	returnRows(scope, noop, columns, rows, err)
+}
+
+// returnRows hands rows to the noop database, or fails the query if they
+// could not be built
+func returnRows(scope *gorm.Scope, noop NoopController, columns []string, rows [][]driver.Value, err error) {
+	if err != nil {
+		scope.Err(err)
 		return
 	}
 
-	recorder.Record(stmt, true)
+	noop.ReturnRows(columns, rows)
+}
+
+// getModelValues returns a single row for the model being queried. Structs
+// return their current values, so scanning the row leaves them unchanged.
+func getModelValues(scope *gorm.Scope) ([]string, [][]driver.Value, error) {
+	if scope.Value == nil {
+		return []string{"count"}, [][]driver.Value{[]driver.Value{int64(0)}}, nil
+	}
+
+	value := indirect(reflect.ValueOf(scope.Value))
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return getDestValues(scope.Value)
+	case reflect.Slice:
+		elem := reflect.New(getElemType(value.Type()))
+		return getDestValues(elem.Interface())
+	default:
+		return []string{"count"}, [][]driver.Value{[]driver.Value{int64(0)}}, nil
+	}
 }
 
-func populateScopeValueCallback(scope *gorm.Scope) {
-	// we need to see if we have a valid outval
-	returnValue, ok := scope.Get("gorm_expect:ret")
+// matchPreload matches a query to the next pending preload for the same
+// model. The schema is stored on the scope, so that recordQueryCallback can
+// record it with the statement.
+func matchPreload(scope *gorm.Scope) string {
+	r, ok := scope.Get("gorm:recorder")
+
+	if !ok {
+		return ""
+	}
 
-	if ok {
-		scope.Value = returnValue
+	recorder := r.(*Recorder)
+
+	if len(recorder.preload) == 0 || scope.Value == nil {
+		return ""
 	}
+
+	// gorm skips preload queries when there is nothing to load, so the
+	// query belongs to the next preload for the same model
+	modelType := getElemType(reflect.TypeOf(scope.Value))
+
+	for i, preload := range recorder.preload {
+		if preload.modelType == modelType {
+			recorder.preload = recorder.preload[i+1:]
+			scope.InstanceSet("gorm_expect:preload", preload.schema)
+
+			return preload.schema
+		}
+	}
+
+	return ""
 }
 
 func recordQueryCallback(scope *gorm.Scope) {
@@ -131,17 +262,18 @@ func recordQueryCallback(scope *gorm.Scope) {
 
 	recorder := r.(*Recorder)
 
-	stmt := Stmt{
+	stmt := canonicalStmt(Stmt{
 		kind: "query",
 		sql:  scope.SQL,
 		args: scope.SQLVars,
-	}
+	})
 
-	if len(recorder.preload) > 0 {
-		stmt.preload = recorder.preload[0].schema
+	if schema, ok := scope.InstanceGet("gorm_expect:preload"); ok {
+		stmt.preload = schema.(string)
+	}
 
-		// we just want to pop the first element off
-		recorder.preload = recorder.preload[1:]
+	if recordWithMatcher(scope, recorder, stmt) {
+		return
 	}
 
 	recorder.Record(stmt, true)
@@ -158,6 +290,7 @@ func recordPreloadCallback(scope *gorm.Scope) {
 	preload := getPreload(scope)
 
 	if len(preload) > 0 {
-		recorder.(*Recorder).preload = preload
+		modelType := getElemType(reflect.TypeOf(scope.Value))
+		recorder.(*Recorder).preload = expandPreload(modelType, preload)
 	}
 }
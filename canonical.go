@@ -0,0 +1,595 @@
+package gormexpect
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// listItem is an item of a comma separated list, as a range of tokens
+type listItem struct {
+	start int // index of the item's first token
+	end   int // index after the item's last token
+	name  string
+}
+
+// columnList is a list whose items are moved by a canonical statement.
+// perm[i] is the index of the item that ends up at position i.
+type columnList struct {
+	items []listItem
+	perm  []int
+}
+
+// canonicalStatement sorts the columns of an INSERT, along with its values,
+// and the assignments of an UPDATE by column name. gorm writes columns in map
+// order when creating or updating from a map, so both the expected and the
+// executed statements are compared in this form. order gives, for each ? in
+// the returned statement, the index of its bind arg in the original one. It
+// is nil if the args keep their order.
+func canonicalStatement(sql string) (string, []int) {
+	tokens, err := tokenize(sql)
+
+	if err != nil || len(tokens) == 0 {
+		return sql, nil
+	}
+
+	var lists []columnList
+
+	switch {
+	case tokens[0].is("INSERT"):
+		lists = insertLists(tokens)
+	case tokens[0].is("UPDATE"):
+		lists = updateLists(tokens)
+	}
+
+	if len(lists) == 0 {
+		return sql, nil
+	}
+
+	var (
+		out      strings.Builder
+		sequence []int // token indices in their new order
+		cursor   int   // byte offset of the next text to copy
+		next     int   // index of the next token to copy
+	)
+
+	for _, list := range lists {
+		first, last := list.items[0], list.items[len(list.items)-1]
+
+		out.WriteString(sql[cursor:tokens[first.start].pos])
+		sequence = appendRange(sequence, next, first.start)
+
+		for i, j := range list.perm {
+			item := list.items[j]
+			out.WriteString(sql[tokens[item.start].pos:tokens[item.end-1].end])
+			sequence = appendRange(sequence, item.start, item.end)
+
+			// keep the separators where they were
+			if i < len(list.items)-1 {
+				out.WriteString(sql[tokens[list.items[i].end-1].end:tokens[list.items[i+1].start].pos])
+			}
+		}
+
+		cursor = tokens[last.end-1].end
+		next = last.end
+	}
+
+	out.WriteString(sql[cursor:])
+	sequence = appendRange(sequence, next, len(tokens))
+
+	return out.String(), placeholderOrder(tokens, sequence)
+}
+
+// insertLists returns the column list of an INSERT and each of its rows of
+// values, which are all sorted by column name
+func insertLists(tokens []token) []columnList {
+	open := -1
+
+	for i, tok := range tokens {
+		if tok.is("VALUES") || tok.is("SELECT") {
+			break
+		}
+
+		if tok.is("(") {
+			open = i
+			break
+		}
+	}
+
+	if open < 0 {
+		return nil
+	}
+
+	closing := closingParen(tokens, open)
+
+	if !tokens[closing].is(")") {
+		return nil
+	}
+
+	columns := splitList(tokens, open+1, closing)
+
+	for i := range columns {
+		name, ok := columnName(tokens[columns[i].start:columns[i].end])
+
+		if !ok {
+			return nil
+		}
+
+		columns[i].name = name
+	}
+
+	perm := sortedPerm(columns)
+
+	if perm == nil || closing+1 >= len(tokens) || !tokens[closing+1].is("VALUES") {
+		return nil
+	}
+
+	lists := []columnList{{items: columns, perm: perm}}
+
+	for i := closing + 2; i < len(tokens) && tokens[i].is("("); {
+		end := closingParen(tokens, i)
+
+		if !tokens[end].is(")") {
+			return nil
+		}
+
+		row := splitList(tokens, i+1, end)
+
+		// a row that doesn't line up with the columns can't be sorted with them
+		if len(row) != len(columns) {
+			return nil
+		}
+
+		lists = append(lists, columnList{items: row, perm: perm})
+		i = end + 1
+
+		if i+1 < len(tokens) && tokens[i].is(",") {
+			i++
+		}
+	}
+
+	if len(lists) == 1 {
+		return nil
+	}
+
+	return lists
+}
+
+// updateLists returns the assignments of an UPDATE, sorted by column name
+func updateLists(tokens []token) []columnList {
+	items := setList(tokens)
+
+	for i, item := range items {
+		assignment := tokens[item.start:item.end]
+		equals := -1
+
+		for j, tok := range assignment {
+			if tok.is("=") {
+				equals = j
+				break
+			}
+		}
+
+		if equals < 0 {
+			return nil
+		}
+
+		name, ok := columnName(assignment[:equals])
+
+		if !ok {
+			return nil
+		}
+
+		items[i].name = name
+	}
+
+	perm := sortedPerm(items)
+
+	if perm == nil {
+		return nil
+	}
+
+	return []columnList{{items: items, perm: perm}}
+}
+
+// setList returns the assignments in the SET clause of an UPDATE, which ends
+// at its WHERE, RETURNING or similar clause. Commas in quotes or parentheses,
+// such as in gorm.Expr("concat(name, ?)", "x"), don't separate assignments.
+func setList(tokens []token) []listItem {
+	set := -1
+
+	for i, tok := range tokens {
+		if tok.is("SET") {
+			set = i
+			break
+		}
+	}
+
+	if set < 0 {
+		return nil
+	}
+
+	end := set + 1
+
+	for depth := 0; end < len(tokens); end++ {
+		if depth == 0 && endsAssignments(tokens[end]) {
+			break
+		}
+
+		depth += parenDepth(tokens[end])
+	}
+
+	return splitList(tokens, set+1, end)
+}
+
+// endsAssignments returns true if tok ends the SET list of an UPDATE
+func endsAssignments(tok token) bool {
+	for _, keyword := range []string{"WHERE", "RETURNING", "FROM", "ORDER", "LIMIT", ";"} {
+		if tok.is(keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitList splits tokens[start:end] at the commas outside parentheses. nil is
+// returned if any item is empty.
+func splitList(tokens []token, start, end int) []listItem {
+	var items []listItem
+	depth := 0
+	itemStart := start
+
+	for i := start; i <= end && i <= len(tokens); i++ {
+		if i == end || (depth == 0 && tokens[i].is(",")) {
+			if i == itemStart {
+				return nil
+			}
+
+			items = append(items, listItem{start: itemStart, end: i})
+			itemStart = i + 1
+			continue
+		}
+
+		depth += parenDepth(tokens[i])
+	}
+
+	return items
+}
+
+// columnName returns the column named by a plain or table qualified name
+func columnName(tokens []token) (string, bool) {
+	switch {
+	case len(tokens) == 1 && isName(tokens[0]):
+		return tokens[0].text, true
+	case len(tokens) == 3 && isName(tokens[0]) && tokens[1].is(".") && isName(tokens[2]):
+		return tokens[2].text, true
+	}
+
+	return "", false
+}
+
+// sortedPerm returns the order that sorts items by name, or nil if they are
+// already sorted
+func sortedPerm(items []listItem) []int {
+	if len(items) < 2 {
+		return nil
+	}
+
+	perm := make([]int, len(items))
+
+	for i := range perm {
+		perm[i] = i
+	}
+
+	sort.SliceStable(perm, func(i, j int) bool {
+		return strings.ToLower(items[perm[i]].name) < strings.ToLower(items[perm[j]].name)
+	})
+
+	for i, j := range perm {
+		if i != j {
+			return perm
+		}
+	}
+
+	return nil
+}
+
+func appendRange(indices []int, start, end int) []int {
+	for i := start; i < end; i++ {
+		indices = append(indices, i)
+	}
+
+	return indices
+}
+
+// placeholderOrder returns the original index of each ? placeholder, in the
+// order given by sequence. Numbered placeholders keep their args wherever
+// they are moved, so nil is returned for them.
+func placeholderOrder(tokens []token, sequence []int) []int {
+	index := make(map[int]int)
+
+	for i, tok := range tokens {
+		if tok.kind != tokenPlaceholder {
+			continue
+		}
+
+		if tok.text != "?" {
+			return nil
+		}
+
+		index[i] = len(index)
+	}
+
+	var order []int
+
+	for _, i := range sequence {
+		if n, ok := index[i]; ok {
+			order = append(order, n)
+		}
+	}
+
+	for i, n := range order {
+		if i != n {
+			return order
+		}
+	}
+
+	return nil
+}
+
+// reorder returns values in the order given by canonicalStatement. values
+// are returned as they are if they don't match the statement's placeholders.
+func reorder[T any](values []T, order []int) []T {
+	if order == nil || len(order) != len(values) {
+		return values
+	}
+
+	reordered := make([]T, len(values))
+
+	for i, n := range order {
+		reordered[i] = values[n]
+	}
+
+	return reordered
+}
+
+// canonicalStmt matches a recorded statement in the canonical form. Only sql,
+// which the pattern is built from, is rewritten. raw and args are kept as they
+// were, so that recorded statements show what gorm executed.
+func canonicalStmt(stmt Stmt) Stmt {
+	if stmt.raw == "" {
+		stmt.raw = stmt.sql
+	}
+
+	stmt.sql, stmt.order = canonicalStatement(stmt.sql)
+
+	return stmt
+}
+
+// matchArgs returns the args of stmt in the order they are matched in, which
+// is the order of the canonical statement
+func (stmt Stmt) matchArgs() []driver.Value {
+	return toDriverValues(reorder(stmt.args, stmt.order))
+}
+
+// canonicalConnector opens connections to go-sqlmock that rewrite every
+// statement into the canonical form before it is matched. A statement that
+// doesn't match a QueryMatcherAST pattern is matched again as its parse tree.
+// Errors from go-sqlmock are rewritten back, so that they show the executed
+// statement.
+type canonicalConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c canonicalConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &canonicalConn{Conn: conn}, nil
+}
+
+func (c canonicalConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type canonicalConn struct {
+	driver.Conn
+}
+
+func (c *canonicalConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *canonicalConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	canonical, order := canonicalStatement(query)
+
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, canonical)
+	} else {
+		stmt, err = c.Conn.Prepare(canonical)
+	}
+
+	if err != nil {
+		return nil, executedError(err, query, canonical, nil, nil)
+	}
+
+	return &canonicalDriverStmt{Stmt: stmt, order: order}, nil
+}
+
+func (c *canonicalConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	canonical, order := canonicalStatement(query)
+	reordered := reorderNamed(args, order)
+	result, err := execer.ExecContext(ctx, canonical, reordered)
+
+	if tree, ok := treeStatement(err, canonical); ok {
+		if treeResult, treeErr := execer.ExecContext(ctx, tree, reordered); !isMismatch(treeErr) {
+			return treeResult, executedError(treeErr, query, tree, args, reordered)
+		}
+	}
+
+	return result, executedError(err, query, canonical, args, reordered)
+}
+
+func (c *canonicalConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	canonical, order := canonicalStatement(query)
+	reordered := reorderNamed(args, order)
+	rows, err := queryer.QueryContext(ctx, canonical, reordered)
+
+	if tree, ok := treeStatement(err, canonical); ok {
+		if treeRows, treeErr := queryer.QueryContext(ctx, tree, reordered); !isMismatch(treeErr) {
+			return treeRows, executedError(treeErr, query, tree, args, reordered)
+		}
+	}
+
+	return rows, executedError(err, query, canonical, args, reordered)
+}
+
+// isMismatch returns true if err is go-sqlmock failing to match a statement
+// against the pattern of the next expectation
+func isMismatch(err error) bool {
+	_, ok := mismatchedPattern(err)
+
+	return ok
+}
+
+// mismatchedPattern returns the pattern of the expectation that go-sqlmock
+// failed to match a statement against, if that is what err is. go-sqlmock
+// has no other way to tell, so this is the only place that depends on the
+// text of its errors. QueryMatcherAST relies on it to find out when to retry
+// with the statement's parse tree.
+func mismatchedPattern(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+
+	msg := err.Error()
+
+	for _, quotes := range []string{"''", "[]"} {
+		prefix := ", does not match regex " + quotes[:1]
+		i := strings.LastIndex(msg, prefix)
+
+		if i >= 0 && strings.HasSuffix(msg, quotes[1:]) {
+			return msg[i+len(prefix) : len(msg)-1], true
+		}
+	}
+
+	return "", false
+}
+
+// executedError rewrites an error from go-sqlmock that mentions the canonical
+// statement or its args to mention the executed ones instead. go-sqlmock
+// collapses whitespace in the statements it reports, and formats args the same
+// way as driver.NamedValue.
+func executedError(err error, query, canonical string, args, reordered []driver.NamedValue) error {
+	if err == nil || query == canonical {
+		return err
+	}
+
+	query, canonical = strings.Join(strings.Fields(query), " "), strings.Join(strings.Fields(canonical), " ")
+	message := err.Error()
+
+	if !strings.Contains(message, canonical) {
+		return err
+	}
+
+	message = strings.Replace(message, canonical, query, -1)
+
+	if args != nil {
+		message = strings.Replace(message, fmt.Sprintf("%+v", reordered), fmt.Sprintf("%+v", args), -1)
+	}
+
+	return errors.New(message)
+}
+
+func (c *canonicalConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+// canonicalDriverStmt passes the args of a prepared statement in the order of
+// its canonical SQL
+type canonicalDriverStmt struct {
+	driver.Stmt
+	order []int
+}
+
+func (s *canonicalDriverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.Stmt.Exec(reorder(args, s.order))
+}
+
+func (s *canonicalDriverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.Stmt.Query(reorder(args, s.order))
+}
+
+func (s *canonicalDriverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, reorderNamed(args, s.order))
+	}
+
+	return s.Stmt.Exec(namedValues(reorderNamed(args, s.order)))
+}
+
+func (s *canonicalDriverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, reorderNamed(args, s.order))
+	}
+
+	return s.Stmt.Query(namedValues(reorderNamed(args, s.order)))
+}
+
+// reorderNamed reorders args, renumbering them to match their new positions
+func reorderNamed(args []driver.NamedValue, order []int) []driver.NamedValue {
+	if order == nil || len(order) != len(args) {
+		return args
+	}
+
+	reordered := reorder(args, order)
+
+	for i := range reordered {
+		reordered[i].Ordinal = i + 1
+	}
+
+	return reordered
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
@@ -0,0 +1,180 @@
+package gormexpect_test
+
+import (
+	"testing"
+	"time"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertColumnOrder(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.ExecSQL(`INSERT INTO "users" ("name","age") VALUES (?,?)`, "jinzhu", 18).WillSucceed(1, 1)
+	result := db.Exec(`INSERT INTO "users" ("age","name") VALUES (?,?)`, 18, "jinzhu")
+
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	expect.ExecSQL(`INSERT INTO "users" ("name","age") VALUES (?,?), (?,?)`, "jinzhu", 18, "itwx", 20).WillSucceed(2, 2)
+	result = db.Exec(`INSERT INTO "users" ("age","name") VALUES (?,?), (?,?)`, 18, "jinzhu", 20, "itwx")
+
+	assert.Nil(t, result.Error)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestInsertColumnArgs(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each arg still has to go with its own column
+	expect.ExecSQL(`INSERT INTO "users" ("name","age") VALUES (?,?)`, "jinzhu", 18).WillSucceed(1, 1)
+	result := db.Exec(`INSERT INTO "users" ("age","name") VALUES (?,?)`, "jinzhu", 18)
+
+	assert.NotNil(t, result.Error)
+
+	db.Exec(`INSERT INTO "users" ("name","age") VALUES (?,?)`, "jinzhu", 18)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestUpdateColumnOrder(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.ExecSQL(`UPDATE "users" SET "name" = ?, "age" = age + ? WHERE "id" = ?`, "jinzhu", 1, 10).WillSucceed(0, 1)
+	result := db.Exec(`UPDATE "users" SET "age" = age + ?, "name" = ? WHERE "id" = ?`, 1, "jinzhu", 10)
+
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(1), result.RowsAffected)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestUpdatesMap(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Id: 1}
+	updates := map[string]interface{}{"name": "jinzhu", "age": 18}
+
+	// gorm writes the columns of a map in its own order
+	expect.Begin()
+	expect.ExecSQL(`UPDATE "users" SET "name" = ?, "age" = ? WHERE "users"."id" = ?`, "jinzhu", 18, 1).WillSucceed(0, 1)
+	expect.Commit()
+	result := db.Model(&user).UpdateColumns(updates)
+
+	assert.Nil(t, result.Error)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestUpdatesMapArgs(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Id: 1}
+	updates := map[string]interface{}{"name": "jinzhu", "age": 18}
+
+	expect.Begin()
+	expect.Model(&user).Updates(updates).WillSucceed(0, 1)
+	expect.Commit()
+
+	// in a transaction of its own, so that gorm doesn't roll back on failure
+	tx := db.Begin()
+	result := tx.Model(&user).Updates(map[string]interface{}{"name": "someone", "age": 99})
+
+	// the args gorm generated are expected too
+	if assert.NotNil(t, result.Error) {
+		assert.Contains(t, result.Error.Error(), "arguments do not match")
+	}
+
+	assert.Nil(t, tx.Model(&user).Updates(updates).Error)
+	assert.Nil(t, tx.Commit().Error)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestCanonicalRecorded(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// statements are only matched in canonical form, and recorded as gorm
+	// wrote them, in the order of the model's fields
+	user := User{Name: "jinzhu", Age: 18}
+	expect.Create(&user)
+	recorded := expect.Recorded()
+
+	if assert.NotEmpty(t, recorded) {
+		assert.Contains(t, recorded[0].SQL, `INSERT INTO "users" ("age","name","email",`)
+		assert.Equal(t, []interface{}{int64(18), "jinzhu", ""}, recorded[0].Args[:3])
+	}
+}
+
+func TestCanonicalExecutedError(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.ExecSQL(`INSERT INTO "users" ("name","age") VALUES (?,?)`, "jinzhu", 18).WillSucceed(1, 1)
+	result := db.Exec(`INSERT INTO "users" ("name","email") VALUES (?,?)`, "jinzhu", "jinzhu@example.org")
+
+	// the error shows the statement as it was executed
+	if assert.NotNil(t, result.Error) {
+		assert.Contains(t, result.Error.Error(), `ExecQuery 'INSERT INTO "users" ("name","email") VALUES (?,?)'`)
+	}
+
+	db.Exec(`INSERT INTO "users" ("name","age") VALUES (?,?)`, "jinzhu", 18)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestUpdateRepeatedColumn(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Id: 1}
+	updates := map[string]interface{}{"name": "jinzhu", "age": 18}
+
+	expect.Begin()
+	expect.Model(&user).Updates(updates).WillSucceed(0, 1)
+	expect.Commit()
+
+	// each assignment has to appear once
+	tx := db.Begin()
+	result := tx.Exec(`UPDATE "users" SET "age" = ?, "age" = ?, "updated_at" = ? WHERE "users"."id" = ?`, 18, 18, time.Now(), 1)
+
+	assert.NotNil(t, result.Error)
+
+	tx.Exec(`UPDATE "users" SET "name" = ?, "age" = ?, "updated_at" = ? WHERE "users"."id" = ?`, "jinzhu", 18, time.Now(), 1)
+	tx.Commit()
+	assert.Nil(t, expect.AssertExpectations())
+}
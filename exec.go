@@ -1,6 +1,9 @@
 package gormexpect
 
 import (
+	"database/sql/driver"
+	"time"
+
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
@@ -20,7 +23,7 @@ type SqlmockExecExpectation struct {
 // This method may also call Query, if there are default columns.
 func (e *SqlmockExecExpectation) WillSucceed(lastReturnedID, rowsAffected int64) ExecExpectation {
 	exec, _ := e.parent.recorder.GetFirst()
-	e.parent.adapter.ExpectExec(exec).WillSucceed(lastReturnedID, rowsAffected)
+	e.execer(exec).WillSucceed(lastReturnedID, rowsAffected)
 
 	// for now, just return empty row
 	if len(e.parent.recorder.stmts) >= 1 {
@@ -33,7 +36,7 @@ func (e *SqlmockExecExpectation) WillSucceed(lastReturnedID, rowsAffected int64)
 				e.parent.adapter.ExpectQuery(query).Returns(sqlmock.NewRows(e.parent.recorder.blankColumns))
 			}
 		case "exec":
-			e.parent.adapter.ExpectExec(query).WillSucceed(1, 1)
+			e.execer(query).WillSucceed(1, 1)
 		default:
 			return e
 		}
@@ -45,7 +48,35 @@ func (e *SqlmockExecExpectation) WillSucceed(lastReturnedID, rowsAffected int64)
 // WillFail sets the exec to fail with the passed error
 func (e *SqlmockExecExpectation) WillFail(err error) ExecExpectation {
 	query, _ := e.parent.recorder.GetFirst()
-	e.parent.adapter.ExpectExec(query).WillFail(err)
+	e.execer(query).WillFail(err)
 
 	return e
 }
+
+// execer expects stmt to be executed with the args gorm generated for it
+func (e *SqlmockExecExpectation) execer(stmt Stmt) Execer {
+	execer := e.parent.adapter.ExpectExec(stmt)
+
+	if len(stmt.args) > 0 {
+		execer = execer.Args(anyTimes(stmt.matchArgs())...)
+	}
+
+	return execer
+}
+
+// anyTimes replaces times in values with sqlmock.AnyArg(). Times such as
+// UpdatedAt are set when a statement is executed, so they can't be expected.
+func anyTimes(values []driver.Value) []driver.Value {
+	for i, value := range values {
+		switch t := value.(type) {
+		case time.Time:
+			values[i] = sqlmock.AnyArg()
+		case *time.Time:
+			if t != nil {
+				values[i] = sqlmock.AnyArg()
+			}
+		}
+	}
+
+	return values
+}
@@ -1,7 +1,11 @@
 package gormexpect
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/jinzhu/gorm"
@@ -20,48 +24,49 @@ type Expecter struct {
 	gorm     *gorm.DB
 	noop     NoopController
 	recorder *Recorder
+	spy      *Spy
 }
 
 // NewDefaultExpecter returns a Expecter powered by go-sqlmock
 func NewDefaultExpecter() (*gorm.DB, *Expecter, error) {
-	gormMock, adapter, err := NewSqlmockAdapter("sqlmock", "mock_gorm_dsn")
+	return NewExpecter(NewSqlmockAdapter, "sqlmock", "mock_gorm_dsn")
+}
+
+// NewExpecter returns an Expecter for arbitrary adapters
+func NewExpecter(fn AdapterFactory, dialect string, args ...interface{}) (*gorm.DB, *Expecter, error) {
+	gormDb, adapter, err := fn(dialect, args...)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	gormDb, spy := spyOn(gormDb)
 	recorder := &Recorder{}
 	noop, noopc, _ := NewNoopDB()
 	gormNoop, _ := gorm.Open("sqlmock", noop)
 	gormNoop = gormNoop.Set("gorm:recorder", recorder)
+	gormNoop = gormNoop.Set("gorm_expect:noop", noopc)
+	gormNoop = gormNoop.Set("gorm_expect:errors", &expectErrors{})
 
 	gormNoop.Callback().Create().After("gorm:create").Register("gorm_expect:record_exec", recordExecCallback)
+	gormNoop.Callback().Query().Before("gorm:query").Register("gorm_expect:noop_rows", noopRowsCallback)
 	gormNoop.Callback().Query().After("gorm:query").Register("gorm_expect:record_query", recordQueryCallback)
 	gormNoop.Callback().Query().Before("gorm:preload").Register("gorm_expect:record_preload", recordPreloadCallback)
+	gormNoop.Callback().RowQuery().Before("gorm:row_query").Register("gorm_expect:noop_row_rows", noopRowsCallback)
 	gormNoop.Callback().RowQuery().After("gorm:row_query").Register("gorm_expect:record_row_query", recordQueryCallback)
 	gormNoop.Callback().Update().After("gorm:update").Register("gorm_expect:record_update", recordExecCallback)
 	gormNoop.Callback().Delete().After("gorm:delete").Register("gorm_expect:record_delete", recordExecCallback)
 
-	return gormMock, &Expecter{
+	return gormDb, &Expecter{
 		adapter:  adapter,
 		callmap:  make(map[string][]interface{}),
 		gorm:     gormNoop,
 		noop:     noopc,
 		recorder: recorder,
+		spy:      spy,
 	}, nil
 }
 
-// NewExpecter returns an Expecter for arbitrary adapters
-func NewExpecter(fn AdapterFactory, dialect string, args ...interface{}) (*gorm.DB, *Expecter, error) {
-	gormDb, adapter, err := fn(dialect, args...)
-
-	if err != nil {
-		return nil, nil, err
-	}
-
-	return gormDb, &Expecter{adapter: adapter}, nil
-}
-
 /* PUBLIC METHODS */
 
 // Debug logs out queries
@@ -91,11 +96,81 @@ func (h *Expecter) Skip(hook string, callbackName string) *Expecter {
 	return clone
 }
 
+// Script queues results for the noop database that expectations are recorded
+// against. Use it when a gorm flow depends on what earlier statements return,
+// e.g. a query that should find nothing, or an UPDATE that should affect rows.
+func (h *Expecter) Script(steps ...NoopStep) *Expecter {
+	h.noop.Script(steps...)
+
+	return h
+}
+
 // AssertExpectations checks if all expected Querys and Execs were satisfied.
+// Errors from building the rows passed to Returns are reported first.
 func (h *Expecter) AssertExpectations() error {
+	if h.gorm != nil {
+		if errs, ok := h.gorm.Get("gorm_expect:errors"); ok && len(*errs.(*expectErrors)) > 0 {
+			return errs.(*expectErrors).err()
+		}
+	}
+
 	return h.adapter.AssertExpectations()
 }
 
+// Recorded returns the statements recorded against the noop database, in the
+// order gorm executed them
+func (h *Expecter) Recorded() []Statement {
+	return h.recorder.Recorded()
+}
+
+// Dump writes the recorded statements to w, one per line. It is useful for
+// finding out why an expectation did not match.
+func (h *Expecter) Dump(w io.Writer) {
+	for i, stmt := range h.recorder.Recorded() {
+		fmt.Fprintf(w, "%d. %s\n", i+1, stmt)
+	}
+}
+
+// Executed returns the statements run through the callbacks of the gorm.DB
+// returned with this Expecter, with their args, duration, rows affected and
+// error. It allows assertions after the fact, instead of declaring
+// expectations. Statements that gorm runs without callbacks are not
+// included: db.Exec, and the Begin, Commit and Rollback of transactions.
+func (h *Expecter) Executed() []Execution {
+	return h.spy.Executed()
+}
+
+// AssertMaxQueries checks that no more than n statements were executed
+// against the gorm.DB returned with this Expecter, counting those listed by
+// Executed. The error lists every statement, which makes N+1 queries easy to
+// spot.
+func (h *Expecter) AssertMaxQueries(n int) error {
+	return checkBudget(h.spy.Executed(), n, "")
+}
+
+// AssertMaxQueriesOn is like AssertMaxQueries, but only counts statements on
+// the given table
+func (h *Expecter) AssertMaxQueriesOn(table string, n int) error {
+	var executed []Execution
+
+	for _, execution := range h.spy.Executed() {
+		if execution.Table == table {
+			executed = append(executed, execution)
+		}
+	}
+
+	return checkBudget(executed, n, fmt.Sprintf(" on %s", table))
+}
+
+// Table sets the table to query, e.g. when scanning into a struct that is
+// not a model
+func (h *Expecter) Table(name string) *Expecter {
+	clone := h.clone()
+	clone.gorm = clone.gorm.Table(name)
+
+	return clone
+}
+
 // Association starts association mode
 func (h *Expecter) Association(column string) *MockAssociation {
 	gormAssociation := h.gorm.Association(column)
@@ -163,6 +238,31 @@ func (h *Expecter) Where(query interface{}, args ...interface{}) *Expecter {
 	return clone
 }
 
+// Select specifies the columns to retrieve. Only those columns are returned
+// for the values passed to Returns.
+func (h *Expecter) Select(query interface{}, args ...interface{}) *Expecter {
+	clone := h.clone()
+	clone.gorm = clone.gorm.Select(query, args...)
+
+	return clone
+}
+
+// Group sets a GROUP BY clause
+func (h *Expecter) Group(query string) *Expecter {
+	clone := h.clone()
+	clone.gorm = clone.gorm.Group(query)
+
+	return clone
+}
+
+// Having sets a HAVING condition, for use with Group
+func (h *Expecter) Having(query interface{}, values ...interface{}) *Expecter {
+	clone := h.clone()
+	clone.gorm = clone.gorm.Having(query, values...)
+
+	return clone
+}
+
 // Not sets a NOT condition(s)
 func (h *Expecter) Not(query interface{}, args ...interface{}) *Expecter {
 	clone := h.clone()
@@ -171,7 +271,10 @@ func (h *Expecter) Not(query interface{}, args ...interface{}) *Expecter {
 	return clone
 }
 
-// Preload clones the expecter and sets a preload condition on gorm.DB
+// Preload clones the expecter and sets a preload condition on gorm.DB.
+// Conditions (including func(*gorm.DB) *gorm.DB) are applied to the preload
+// query, whose args must then match. The rows returned for it are the values
+// passed to Returns, so they should already satisfy the conditions.
 func (h *Expecter) Preload(column string, conditions ...interface{}) *Expecter {
 	h.gorm = h.gorm.Preload(column, conditions...)
 
@@ -223,7 +326,17 @@ func (h *Expecter) FirstOrCreate(out interface{}, returns interface{}, where ...
 	clone := h.new()
 	clone.recorder.stmts = []Stmt{query}
 	queryExpectation := SqlmockQueryExpectation{parent: clone, scope: (&gorm.Scope{}).New(returns)}
-	h.adapter.ExpectQuery(query).Returns(queryExpectation.getDestRows(returns))
+	rows, err := queryExpectation.getDestRows(returns)
+
+	if err != nil {
+		addExpectError(h.gorm, err)
+		h.adapter.ExpectQuery(query).Errors(err)
+		h.reset()
+
+		return nil
+	}
+
+	h.adapter.ExpectQuery(query).Returns(rows)
 
 	// we need to deal with the UPDATE if there are more queries
 	if !h.recorder.IsEmpty() {
@@ -257,6 +370,33 @@ func (h *Expecter) Find(out interface{}, where ...interface{}) QueryExpectation
 	return h.query()
 }
 
+// Scan triggers a query on the model set with Model or Table, scanning the
+// results into out. out does not have to be the model: Returns should be
+// given a value of out's type, and its fields are mapped to columns by
+// gorm's naming rules.
+func (h *Expecter) Scan(out interface{}) QueryExpectation {
+	h.callmap["Scan"] = []interface{}{out}
+
+	return h.query()
+}
+
+// Rows triggers a query whose rows are read by the caller, as with
+// gorm.DB.Rows. This suits aggregates: Returns accepts a slice of structs, a
+// map, whose keys and values become the two selected columns, or a single
+// value such as a sum.
+func (h *Expecter) Rows() QueryExpectation {
+	h.callmap["Rows"] = []interface{}{nil}
+
+	return h.query()
+}
+
+// Row is like Rows, for queries that return a single row
+func (h *Expecter) Row() QueryExpectation {
+	h.callmap["Row"] = []interface{}{nil}
+
+	return h.query()
+}
+
 // Count triggers a query
 func (h *Expecter) Count(out interface{}) QueryExpectation {
 	var args []interface{}
@@ -294,6 +434,31 @@ func (h *Expecter) Delete(model interface{}, where ...interface{}) ExecExpectati
 	return h.exec()
 }
 
+// expectErrors collects errors from building the rows of expectations, e.g.
+// fields that cannot be converted to driver values
+type expectErrors []error
+
+func (e *expectErrors) err() error {
+	if len(*e) == 1 {
+		return (*e)[0]
+	}
+
+	messages := make([]string, len(*e))
+
+	for i, err := range *e {
+		messages[i] = err.Error()
+	}
+
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+// addExpectError records err, to be reported by AssertExpectations
+func addExpectError(db *gorm.DB, err error) {
+	if errs, ok := db.Get("gorm_expect:errors"); ok {
+		*errs.(*expectErrors) = append(*errs.(*expectErrors), err)
+	}
+}
+
 // clone ensures that the original expecter does not have any unintended
 // conditions set by Where/Not etc. Recorder is _not_ cloned.
 func (h *Expecter) clone() *Expecter {
@@ -303,6 +468,7 @@ func (h *Expecter) clone() *Expecter {
 		gorm:     h.gorm,
 		noop:     h.noop,
 		recorder: h.recorder,
+		spy:      h.spy,
 	}
 }
 
@@ -314,12 +480,14 @@ func (h *Expecter) new() *Expecter {
 		gorm:     h.gorm,
 		noop:     h.noop,
 		recorder: &Recorder{},
+		spy:      h.spy,
 	}
 }
 
 func (h *Expecter) reset() {
 	h.callmap = make(map[string][]interface{})
 	h.recorder.stmts = []Stmt{}
+	h.recorder.preload = []Preload{}
 }
 
 // query returns a SqlmockQuery with the current DB state
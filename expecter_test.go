@@ -1,6 +1,7 @@
 package gormexpect_test
 
 import (
+	"bytes"
 	"database/sql"
 	"errors"
 	"reflect"
@@ -10,6 +11,7 @@ import (
 	expecter "github.com/iantanwx/gorm-expect"
 	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
 type User struct {
@@ -25,6 +27,25 @@ type User struct {
 	CreditCard   CreditCard
 	Languages    []Language `gorm:"many2many:user_languages;"`
 	PasswordHash []byte
+	Orders       []Order
+}
+
+type Order struct {
+	Id     int64
+	UserId int64
+	Items  []Item
+}
+
+type Item struct {
+	Id        int64
+	OrderId   int64
+	ProductId int64
+	Product   Product
+}
+
+type Product struct {
+	Id   int64
+	Name string
 }
 
 type CreditCard struct {
@@ -40,6 +61,7 @@ type Email struct {
 	Id        int16
 	UserId    int
 	Email     string `sql:"type:varchar(100);"`
+	Verified  bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -50,6 +72,31 @@ type Language struct {
 	Users []User `gorm:"many2many:user_languages;"`
 }
 
+type Comment struct {
+	Id          int64
+	UserId      int64
+	User        User
+	Body        string
+	Attachments []Attachment `gorm:"polymorphic:Owner;"`
+}
+
+type Attachment struct {
+	Id        int64
+	OwnerId   int64
+	OwnerType string
+	Url       string
+}
+
+type Post struct {
+	Id   int64
+	Tags []Tag `gorm:"many2many:post_tags;"`
+}
+
+type Tag struct {
+	Namespace string `gorm:"primary_key"`
+	Name      string `gorm:"primary_key"`
+}
+
 type UserRepository struct {
 	db *gorm.DB
 }
@@ -93,6 +140,28 @@ func TestNewCustomExpecter(t *testing.T) {
 	}
 }
 
+func TestNewCustomExpecterRecorded(t *testing.T) {
+	db, expect, err := expecter.NewExpecter(expecter.NewSqlmockAdapter, "sqlmock", "mock_gorm_dsn")
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Name: "jinzhu"}
+
+	expect.First(&in).Returns(out)
+	db.First(&in)
+
+	dump := bytes.NewBufferString("")
+	expect.Dump(dump)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Len(t, expect.Recorded(), 1)
+	assert.Contains(t, dump.String(), `SELECT * FROM "users"`)
+}
+
 func TestQuery(t *testing.T) {
 	db, expect, err := expecter.NewDefaultExpecter()
 	defer func() {
@@ -257,6 +326,110 @@ func TestPreloadHasMany(t *testing.T) {
 	assert.Equal(t, out, in)
 }
 
+func TestPreloadConditions(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	outEmails := []Email{Email{Id: 1, UserId: 1, Verified: true}}
+	out := User{Id: 1, Emails: outEmails}
+
+	expect.Preload("Emails", "verified = ?", true).Find(&in).Returns(out)
+	err = db.Preload("Emails", "verified = ?", true).Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
+func TestPreloadConditionsArgs(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Emails: []Email{}}
+
+	expect.Preload("Emails", "verified = ?", true).Find(&in).Returns(out)
+	err = db.Preload("Emails", "verified = ?", false).Find(&in).Error
+
+	assert.NotNil(t, err)
+
+	db.Raw(`SELECT * FROM "emails" WHERE ("user_id" IN (?)) AND (verified = ?)`, 1, true).Scan(&[]Email{})
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestPreloadConditionsKeyArgs(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []User{}
+	out := []User{User{Id: 1, Orders: []Order{}}, User{Id: 2, Orders: []Order{}}}
+
+	// a condition arg that equals a parent key must still match exactly
+	expect.Preload("Orders", "id = ?", 1).Find(&in).Returns(out)
+	err = db.Preload("Orders", "id = ?", 2).Find(&in).Error
+
+	assert.NotNil(t, err)
+
+	db.Raw(`SELECT * FROM "orders" WHERE ("user_id" IN (?,?)) AND (id = ?)`, 1, 2, 1).Scan(&[]Order{})
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestPreloadConditionsAnyArg(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Emails: []Email{Email{Id: 1, UserId: 1, Email: "jinzhu@example.org"}}}
+
+	expect.Preload("Emails", "email LIKE ?", sqlmock.AnyArg()).Find(&in).Returns(out)
+	err = db.Preload("Emails", "email LIKE ?", "%@example.org").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
+func TestPreloadConditionsFunc(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := func(db *gorm.DB) *gorm.DB {
+		return db.Where("verified = ?", true).Order("created_at DESC")
+	}
+
+	in := User{Id: 1}
+	outEmails := []Email{Email{Id: 2, UserId: 1, Verified: true}, Email{Id: 1, UserId: 1, Verified: true}}
+	out := User{Id: 1, Emails: outEmails}
+
+	expect.Preload("Emails", verified).Find(&in).Returns(out)
+	err = db.Preload("Emails", verified).Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
 func TestPreloadHasOne(t *testing.T) {
 	db, expect, err := expecter.NewDefaultExpecter()
 	defer db.Close()
@@ -304,6 +477,26 @@ func TestPreloadMany2Many(t *testing.T) {
 	}
 }
 
+func TestPreloadMany2ManyCompositeKeys(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := Post{Id: 1}
+	tags := []Tag{Tag{Namespace: "lang", Name: "go"}, Tag{Namespace: "db", Name: "sql"}}
+	out := Post{Id: 1, Tags: tags}
+
+	expect.Preload("Tags").Find(&in).Returns(out)
+	err = db.Preload("Tags").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
 func TestPreloadMany2ManyEmpty(t *testing.T) {
 	db, expect, err := expecter.NewDefaultExpecter()
 	defer db.Close()
@@ -325,6 +518,188 @@ func TestPreloadMany2ManyEmpty(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestPreloadBelongsTo(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := Comment{Id: 1}
+	out := Comment{Id: 1, UserId: 1, User: User{Id: 1, Name: "jinzhu"}, Body: "first!"}
+
+	expect.Preload("User").Find(&in).Returns(out)
+	err = db.Preload("User").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
+func TestPreloadPolymorphic(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := Comment{Id: 1}
+	attachments := []Attachment{
+		Attachment{Id: 1, Url: "https://example.com/1.png"},
+		Attachment{Id: 2, Url: "https://example.com/2.png"},
+	}
+	out := Comment{Id: 1, Attachments: attachments}
+
+	expect.Preload("Attachments").Find(&in).Returns(out)
+	err = db.Preload("Attachments").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(in.Attachments))
+
+	for i, attachment := range in.Attachments {
+		assert.Equal(t, attachments[i].Url, attachment.Url)
+		assert.Equal(t, "comments", attachment.OwnerType)
+		assert.Equal(t, int64(1), attachment.OwnerId)
+	}
+
+	// the value passed to Returns is left untouched
+	assert.Equal(t, "", attachments[0].OwnerType)
+}
+
+func TestPreloadNested(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyboard := Product{Id: 1, Name: "keyboard"}
+	mouse := Product{Id: 2, Name: "mouse"}
+	orders := []Order{
+		Order{Id: 1, UserId: 1, Items: []Item{
+			Item{Id: 1, OrderId: 1, ProductId: 1, Product: keyboard},
+			Item{Id: 2, OrderId: 1, ProductId: 2, Product: mouse},
+		}},
+		Order{Id: 2, UserId: 1, Items: []Item{
+			Item{Id: 3, OrderId: 2, ProductId: 1, Product: keyboard},
+		}},
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Orders: orders}
+
+	expect.Preload("Orders.Items.Product").Find(&in).Returns(out)
+	err = db.Preload("Orders.Items.Product").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
+func TestPreloadNestedBlankKeys(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys that gorm needs to match items to their orders are filled in
+	in := User{Id: 1}
+	out := User{Id: 1, Orders: []Order{
+		Order{Id: 1, Items: []Item{Item{Id: 1}, Item{Id: 2}}},
+		Order{Id: 2, Items: []Item{Item{Id: 3}}},
+	}}
+
+	expect.Preload("Orders").Preload("Orders.Items").Find(&in).Returns(out)
+	err = db.Preload("Orders").Preload("Orders.Items").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(in.Orders))
+	assert.Equal(t, 2, len(in.Orders[0].Items))
+	assert.Equal(t, 1, len(in.Orders[1].Items))
+	assert.Equal(t, int64(2), in.Orders[1].Items[0].OrderId)
+}
+
+func TestPreloadSlice(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	english := Language{Name: "EN"}
+	english.ID = 1
+	chinese := Language{Name: "ZH"}
+	chinese.ID = 2
+
+	out := []User{
+		User{
+			Id:         1,
+			Emails:     []Email{Email{Id: 1, UserId: 1}, Email{Id: 2, UserId: 1}},
+			CreditCard: CreditCard{ID: 1, Number: "12345678", UserId: sql.NullInt64{Int64: 1, Valid: true}},
+			Languages:  []Language{english, chinese},
+		},
+		User{
+			Id:        2,
+			Emails:    []Email{Email{Id: 3, UserId: 2}},
+			Languages: []Language{chinese},
+		},
+		User{
+			Id:        3,
+			Emails:    []Email{},
+			Languages: []Language{},
+		},
+	}
+
+	var in []User
+
+	expect.Preload("Emails").Preload("CreditCard").Preload("Languages").Find(&in).Returns(out)
+	err = db.Preload("Emails").Preload("CreditCard").Preload("Languages").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
+func TestPreloadSliceBlankKeys(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := []*User{
+		&User{Id: 1, Emails: []Email{Email{Email: "jinzhu@gmail.com"}}},
+		&User{Id: 2, Emails: []Email{Email{Email: "uhznij@liamg.moc"}}},
+	}
+
+	var in []*User
+
+	expect.Preload("Emails").Find(&in).Returns(out)
+	err = db.Preload("Emails").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(in))
+
+	for i, user := range in {
+		assert.Equal(t, 1, len(user.Emails))
+		assert.Equal(t, out[i].Emails[0].Email, user.Emails[0].Email)
+		assert.Equal(t, int(user.Id), user.Emails[0].UserId)
+	}
+
+	// the value passed to Returns is left untouched
+	assert.Equal(t, 0, out[0].Emails[0].UserId)
+}
+
 func TestPreloadMultiple(t *testing.T) {
 	db, expect, err := expecter.NewDefaultExpecter()
 	defer db.Close()
@@ -751,3 +1126,34 @@ func TestUserRepoPreload2(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expected, actual)
 }
+
+func TestRecorded(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Emails: []Email{Email{Id: 1, UserId: 1}}}
+
+	expect.Preload("Emails").Where("name = ?", "jinzhu").Find(&in).Returns(out)
+	db.Preload("Emails").Where("name = ?", "jinzhu").Find(&in)
+
+	recorded := expect.Recorded()
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, 2, len(recorded))
+	assert.Equal(t, "query", recorded[0].Kind)
+	assert.Equal(t, `SELECT * FROM "users"  WHERE "users"."id" = ? AND ((name = ?))`, recorded[0].SQL)
+	assert.Equal(t, []interface{}{int64(1), "jinzhu"}, recorded[0].Args)
+	assert.Equal(t, "", recorded[0].Preload)
+	assert.Equal(t, "Emails", recorded[1].Preload)
+
+	var buf bytes.Buffer
+	expect.Dump(&buf)
+
+	assert.Contains(t, buf.String(), `1. query: SELECT * FROM "users"`)
+	assert.Contains(t, buf.String(), `(preload Emails)`)
+}
@@ -0,0 +1,295 @@
+// Package factory builds populated gorm models to pass to Returns, so tests
+// only need to spell out the fields they care about:
+//
+//	users := factory.Build[User](10, factory.Associate("Emails", 2))
+//	expect.Preload("Emails").Find(&in).Returns(users)
+//
+// Models are inspected with the same gorm schema that gormexpect uses to turn
+// them into rows. Primary keys are filled from a sequence, string fields get
+// a value derived from their column and the sequence, and fields with a
+// default tag get their default. Everything else is left zero unless
+// overridden.
+package factory
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/iantanwx/gorm-expect/internal/relation"
+	"github.com/jinzhu/gorm"
+)
+
+// Option customises the instances built by a factory
+type Option func(*builder)
+
+// With overrides a field, given by field or column name, on every instance.
+// If value is a func(n int) interface{}, it is called with each instance's
+// sequence number instead.
+func With(field string, value interface{}) Option {
+	if fn, ok := value.(func(n int) interface{}); ok {
+		return Sequence(field, fn)
+	}
+
+	return Sequence(field, func(int) interface{} { return value })
+}
+
+// Sequence sets a field from the sequence number of each instance, which
+// counts from 1 for every model type built by a single call to Build.
+func Sequence(field string, fn func(n int) interface{}) Option {
+	return func(b *builder) {
+		b.overrides = append(b.overrides, override{field, fn})
+	}
+}
+
+// Associate builds associated records for an association field. Slice
+// fields get n records, other fields get one. Foreign keys of the associated
+// records are filled in the same way gorm does when saving them.
+func Associate(field string, n int, opts ...Option) Option {
+	return func(b *builder) {
+		b.associations = append(b.associations, association{field, n, opts})
+	}
+}
+
+// Build returns n instances of T. It panics if T is not a gorm model or an
+// option does not match its schema, since that is always a mistake in the
+// test itself.
+func Build[T any](n int, opts ...Option) []T {
+	out := make([]T, n)
+	s := &session{sequences: map[reflect.Type]int{}}
+	b := newBuilder(opts)
+
+	for i := range out {
+		value := reflect.ValueOf(&out[i]).Elem()
+
+		if err := s.build(value, b); err != nil {
+			panic(fmt.Sprintf("factory: %s", err))
+		}
+	}
+
+	return out
+}
+
+// One returns a single instance of T
+func One[T any](opts ...Option) T {
+	return Build[T](1, opts...)[0]
+}
+
+type override struct {
+	field string
+	fn    func(n int) interface{}
+}
+
+type association struct {
+	field string
+	n     int
+	opts  []Option
+}
+
+type builder struct {
+	overrides    []override
+	associations []association
+}
+
+func newBuilder(opts []Option) *builder {
+	b := &builder{}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// session numbers the instances of each model type built by one call to
+// Build, including associated records
+type session struct {
+	sequences map[reflect.Type]int
+}
+
+// build fills value, which is a model struct or a pointer to one
+func (s *session) build(value reflect.Value, b *builder) error {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("%s is not a model struct", value.Type())
+	}
+
+	s.sequences[value.Type()]++
+	n := s.sequences[value.Type()]
+	scope := schema().NewScope(value.Addr().Interface())
+
+	for _, field := range scope.Fields() {
+		if err := setDefault(field, n); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range b.overrides {
+		field, ok := relation.FindField(scope, o.field)
+
+		if !ok {
+			return fmt.Errorf("%s has no field %s", value.Type(), o.field)
+		}
+
+		if err := field.Set(o.fn(n)); err != nil {
+			return fmt.Errorf("%s.%s: %s", value.Type(), field.Name, err)
+		}
+	}
+
+	for _, a := range b.associations {
+		field, ok := relation.FindField(scope, a.field)
+
+		if !ok || field.Relationship == nil {
+			return fmt.Errorf("%s has no association %s", value.Type(), a.field)
+		}
+
+		if err := s.associate(scope, field, a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// associate builds the associated records of field
+func (s *session) associate(scope *gorm.Scope, field *gorm.Field, a association) error {
+	b := newBuilder(a.opts)
+
+	if field.Field.Kind() != reflect.Slice {
+		if err := s.build(field.Field, b); err != nil {
+			return err
+		}
+
+		relation.SetForeignKeys(scope, field, field.Field)
+
+		return nil
+	}
+
+	slice := reflect.MakeSlice(field.Field.Type(), a.n, a.n)
+
+	for i := 0; i < a.n; i++ {
+		if err := s.build(slice.Index(i), b); err != nil {
+			return err
+		}
+
+		relation.SetForeignKeys(scope, field, slice.Index(i))
+	}
+
+	field.Field.Set(slice)
+
+	return nil
+}
+
+// setDefault fills a plain field with its sequence, default tag or a value
+// derived from its column
+func setDefault(field *gorm.Field, n int) error {
+	if field.Relationship != nil || !field.IsNormal {
+		return nil
+	}
+
+	if field.IsPrimaryKey {
+		switch field.Field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return field.Set(n)
+		case reflect.String:
+			return field.Set(strconv.Itoa(n))
+		}
+
+		return nil
+	}
+
+	if value, ok := field.TagSettingsGet("DEFAULT"); ok {
+		return setDefaultTag(field, value)
+	}
+
+	if field.Field.Kind() == reflect.String {
+		field.Field.SetString(fmt.Sprintf("%s_%d", field.DBName, n))
+	}
+
+	return nil
+}
+
+// setDefaultTag parses a default tag such as 'Tech in Asia' or 18 into a
+// field. Defaults that are SQL expressions, like now(), are skipped.
+func setDefaultTag(field *gorm.Field, value string) error {
+	value = strings.TrimSpace(value)
+
+	switch field.Field.Kind() {
+	case reflect.String:
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			field.Field.SetString(strings.Replace(value[1:len(value)-1], "''", "'", -1))
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			field.Field.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			field.Field.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u, err := strconv.ParseUint(value, 10, 64); err == nil {
+			field.Field.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			field.Field.SetFloat(f)
+		}
+	}
+
+	return nil
+}
+
+var (
+	schemaOnce sync.Once
+	schemaDB   *gorm.DB
+)
+
+// schema returns a gorm DB that is only used to inspect models. gorm needs
+// one to work out table names, but never sends it any statements here.
+func schema() *gorm.DB {
+	schemaOnce.Do(func() {
+		db, err := gorm.Open("common", noDB{})
+
+		if err != nil {
+			panic(err)
+		}
+
+		schemaDB = db
+	})
+
+	return schemaDB
+}
+
+var errNoDB = errors.New("factory: models are never sent to a database")
+
+// noDB satisfies gorm.SQLCommon without a connection
+type noDB struct{}
+
+func (noDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return nil, errNoDB
+}
+
+func (noDB) Prepare(query string) (*sql.Stmt, error) {
+	return nil, errNoDB
+}
+
+func (noDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errNoDB
+}
+
+func (noDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return nil
+}
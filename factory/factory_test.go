@@ -0,0 +1,108 @@
+package factory_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/iantanwx/gorm-expect/factory"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+type User struct {
+	Id         int64
+	Name       string
+	Company    string `sql:"default:'Tech in Asia'"`
+	Age        int64
+	Emails     []Email
+	CreditCard CreditCard
+	Languages  []Language `gorm:"many2many:user_languages;"`
+}
+
+type Email struct {
+	Id     int16
+	UserId int
+	Email  string
+}
+
+type CreditCard struct {
+	ID     int
+	Number string
+	UserId sql.NullInt64
+}
+
+type Language struct {
+	gorm.Model
+	Name string
+}
+
+type Order struct {
+	Id     int64
+	UserId int64
+	User   *User
+}
+
+func TestBuild(t *testing.T) {
+	users := factory.Build[User](2)
+
+	assert.Equal(t, []User{
+		User{Id: 1, Name: "name_1", Company: "Tech in Asia"},
+		User{Id: 2, Name: "name_2", Company: "Tech in Asia"},
+	}, users)
+}
+
+func TestBuildOverrides(t *testing.T) {
+	users := factory.Build[*User](2,
+		factory.With("name", "jinzhu"),
+		factory.With("Age", func(n int) interface{} { return 20 + n }),
+	)
+
+	assert.Equal(t, &User{Id: 1, Name: "jinzhu", Company: "Tech in Asia", Age: 21}, users[0])
+	assert.Equal(t, &User{Id: 2, Name: "jinzhu", Company: "Tech in Asia", Age: 22}, users[1])
+
+	assert.PanicsWithValue(t, "factory: factory_test.User has no field Nickname", func() {
+		factory.Build[User](1, factory.With("Nickname", "jinzhu"))
+	})
+}
+
+func TestBuildAssociations(t *testing.T) {
+	users := factory.Build[User](2,
+		factory.Associate("Emails", 2, factory.Sequence("Email", func(n int) interface{} {
+			return fmt.Sprintf("user%d@example.org", n)
+		})),
+		factory.Associate("CreditCard", 1),
+		factory.Associate("Languages", 1),
+	)
+
+	assert.Equal(t, []Email{
+		Email{Id: 3, UserId: 2, Email: "user3@example.org"},
+		Email{Id: 4, UserId: 2, Email: "user4@example.org"},
+	}, users[1].Emails)
+	assert.Equal(t, CreditCard{ID: 2, Number: "number_2", UserId: sql.NullInt64{Int64: 2, Valid: true}}, users[1].CreditCard)
+	assert.Equal(t, uint(2), users[1].Languages[0].ID)
+
+	order := factory.One[Order](factory.With("Id", 10), factory.Associate("User", 1, factory.With("Id", 5)))
+
+	assert.Equal(t, int64(5), order.UserId)
+	assert.Equal(t, int64(5), order.User.Id)
+}
+
+func TestBuildReturns(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []User{}
+	out := factory.Build[User](3, factory.Associate("Emails", 2))
+
+	expect.Preload("Emails").Find(&in).Returns(out)
+	db.Preload("Emails").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
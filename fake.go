@@ -0,0 +1,551 @@
+package gormexpect
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FakeAdapter implements Adapter with an in-memory database. Instead of
+// checking expectations, it keeps a table for every model, so that rows that
+// are created can be found, updated and deleted again. gorm still generates
+// and executes SQL against a noop database; the FakeAdapter's callbacks decide
+// what that SQL returns.
+//
+// Conditions may use =, <>, <, >, IN, IS NULL, LIKE, AND, OR and NOT, which
+// covers the conditions gorm generates itself. Joins, GROUP BY and
+// transactions (which are never rolled back) are not supported.
+type FakeAdapter struct {
+	sync.Mutex
+	noop   NoopController
+	tables map[string]*fakeTable
+}
+
+type fakeTable struct {
+	columns []string
+	rows    []fakeRow
+	lastID  int64
+}
+
+type fakeRow map[string]driver.Value
+
+// NewFakeAdapter returns a gorm.DB and a FakeAdapter. Like
+// NewSqlmockAdapter, it ignores its args. Only the fluent API is supported;
+// db.Raw and db.Exec statements are not run against the fake tables.
+func NewFakeAdapter(dialect string, args ...interface{}) (*gorm.DB, Adapter, error) {
+	noop, noopc, err := NewNoopDB()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gormDb, err := gorm.Open("sqlmock", noop)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fake := &FakeAdapter{noop: noopc, tables: make(map[string]*fakeTable)}
+
+	gormDb.Callback().Create().Before("gorm:create").Register("gorm_expect:fake_before_create", fake.beforeCreateCallback)
+	gormDb.Callback().Create().After("gorm:create").Register("gorm_expect:fake_create", fake.createCallback)
+	gormDb.Callback().Query().Before("gorm:query").Register("gorm_expect:fake_query", fake.queryCallback)
+	gormDb.Callback().RowQuery().Before("gorm:row_query").Register("gorm_expect:fake_row_query", fake.queryCallback)
+	gormDb.Callback().Update().Before("gorm:update").Register("gorm_expect:fake_update", fake.updateCallback)
+	gormDb.Callback().Delete().Before("gorm:delete").Register("gorm_expect:fake_delete", fake.deleteCallback)
+
+	return gormDb, fake, nil
+}
+
+// ExpectQuery implements Adapter. Expectations are ignored.
+func (f *FakeAdapter) ExpectQuery(stmt Stmt) Queryer {
+	return &ignoredQueryer{}
+}
+
+// ExpectExec implements Adapter. Expectations are ignored.
+func (f *FakeAdapter) ExpectExec(stmt Stmt) Execer {
+	return &ignoredExecer{}
+}
+
+// ExpectBegin implements Adapter. Expectations are ignored.
+func (f *FakeAdapter) ExpectBegin() TxBeginner {
+	return &ignoredTxBeginner{}
+}
+
+// ExpectCommit implements Adapter. Expectations are ignored.
+func (f *FakeAdapter) ExpectCommit() TxCommitter {
+	return &ignoredTxCommitter{}
+}
+
+// ExpectRollback implements Adapter. Expectations are ignored.
+func (f *FakeAdapter) ExpectRollback() TxRollback {
+	return &ignoredTxRollback{}
+}
+
+// AssertExpectations implements Adapter. There are no expectations, so it
+// always returns nil.
+func (f *FakeAdapter) AssertExpectations() error {
+	return nil
+}
+
+// Rows returns a copy of the rows in a table, keyed by column
+func (f *FakeAdapter) Rows(table string) []map[string]driver.Value {
+	f.Lock()
+	defer f.Unlock()
+
+	var rows []map[string]driver.Value
+
+	if t, ok := f.tables[table]; ok {
+		for _, row := range t.rows {
+			rows = append(rows, row.copy())
+		}
+	}
+
+	return rows
+}
+
+// table returns the table for the scope's model, creating it if necessary.
+// Callers must hold f's lock.
+func (f *FakeAdapter) table(scope *gorm.Scope) *fakeTable {
+	name := scope.TableName()
+	table, ok := f.tables[name]
+
+	if !ok {
+		table = &fakeTable{}
+
+		for _, field := range scope.GetModelStruct().StructFields {
+			if field.IsNormal && !field.IsIgnored {
+				table.columns = append(table.columns, field.DBName)
+			}
+		}
+
+		f.tables[name] = table
+	}
+
+	return table
+}
+
+// beforeCreateCallback runs before gorm:create. It rejects duplicate primary
+// keys, and reserves the next ID for blank ones while holding f's lock, so
+// that concurrent creates never get the same ID.
+func (f *FakeAdapter) beforeCreateCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	table := f.table(scope)
+	field := scope.PrimaryField()
+
+	if field != nil && field.IsBlank {
+		table.lastID++
+		f.noop.ReturnExecResult(table.lastID, 1)
+		return
+	}
+
+	if field != nil {
+		value, _ := getFieldValue(field.Field)
+
+		for _, row := range table.rows {
+			if cmp, ok := compareValues(row[field.DBName], value); ok && cmp == 0 {
+				scope.Err(fmt.Errorf("Duplicate primary key %v in %s", value, scope.TableName()))
+				return
+			}
+		}
+	}
+
+	f.noop.ReturnExecResult(0, 1)
+}
+
+// createCallback runs after gorm:create, once the primary key is set
+func (f *FakeAdapter) createCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	table := f.table(scope)
+	row := make(fakeRow)
+
+	for _, field := range scope.Fields() {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+
+		if field.IsBlank && field.HasDefaultValue {
+			defaultValue, _ := field.TagSettingsGet("DEFAULT")
+			row[field.DBName] = parseDefaultValue(defaultValue)
+			continue
+		}
+
+		value, err := getFieldValue(field.Field)
+
+		if err != nil {
+			scope.Err(fmt.Errorf("Cannot store %s.%s of type %s: %s", scope.TableName(), field.DBName, field.Field.Type(), err))
+			return
+		}
+
+		row[field.DBName] = value
+
+		if id, ok := value.(int64); ok && field.IsPrimaryKey && id > table.lastID {
+			table.lastID = id
+		}
+	}
+
+	table.rows = append(table.rows, row)
+}
+
+// queryCallback runs before gorm:query and gorm:row_query, and makes the
+// noop database return the matching rows
+func (f *FakeAdapter) queryCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	table := f.table(scope)
+	rows, err := f.match(scope, table, true)
+
+	if err != nil {
+		scope.Err(err)
+		return
+	}
+
+	columns, err := selectColumns(scope, table)
+
+	if err != nil {
+		scope.Err(err)
+		return
+	}
+
+	// Count
+	if columns == nil {
+		f.noop.ReturnRows([]string{"count"}, [][]driver.Value{[]driver.Value{int64(len(rows))}})
+		return
+	}
+
+	var values [][]driver.Value
+
+	for _, row := range rows {
+		value := make([]driver.Value, len(columns))
+
+		for i, column := range columns {
+			value[i] = row[column]
+		}
+
+		values = append(values, value)
+	}
+
+	f.noop.ReturnRows(columns, values)
+}
+
+// updateCallback runs before gorm:update. It updates the matching rows with
+// the columns gorm is about to update.
+func (f *FakeAdapter) updateCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	values := make(map[string]interface{})
+
+	if updateAttrs, ok := scope.InstanceGet("gorm:update_attrs"); ok {
+		values = updateAttrs.(map[string]interface{})
+	} else {
+		for _, field := range scope.Fields() {
+			if !field.IsPrimaryKey && field.IsNormal && !field.IsIgnored && (field.Name != "CreatedAt" || !field.IsBlank) {
+				values[field.DBName] = field.Field.Interface()
+			}
+		}
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	table := f.table(scope)
+	rows, err := f.match(scope, table, false)
+
+	if err != nil {
+		scope.Err(err)
+		return
+	}
+
+	updates := make(map[string]fakeExpr)
+
+	for column, value := range values {
+		if expr, ok := value.(*gorm.SqlExpr); ok {
+			operand, err := parseExpr(scope, expr)
+
+			if err != nil {
+				scope.Err(fmt.Errorf("Cannot update %s.%s: %s", scope.TableName(), column, err))
+				return
+			}
+
+			updates[column] = operand
+			continue
+		}
+
+		converted, err := getFieldValue(reflect.ValueOf(value))
+
+		if err != nil {
+			scope.Err(fmt.Errorf("Cannot store %s.%s of type %T: %s", scope.TableName(), column, value, err))
+			return
+		}
+
+		updates[column] = func(fakeRow) (driver.Value, error) { return converted, nil }
+	}
+
+	// expressions see the row as it was before the update
+	updated := make([]fakeRow, len(rows))
+
+	for i, row := range rows {
+		updated[i] = row.copy()
+
+		for column, operand := range updates {
+			value, err := operand(row)
+
+			if err != nil {
+				scope.Err(fmt.Errorf("Cannot update %s.%s: %s", scope.TableName(), column, err))
+				return
+			}
+
+			updated[i][column] = value
+		}
+	}
+
+	for i, row := range rows {
+		for column := range updates {
+			row[column] = updated[i][column]
+		}
+	}
+
+	f.noop.ReturnExecResult(0, int64(len(rows)))
+}
+
+// deleteCallback runs before gorm:delete. Models with a DeletedAt field are
+// soft deleted, just like gorm does.
+func (f *FakeAdapter) deleteCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	table := f.table(scope)
+	rows, err := f.match(scope, table, false)
+
+	if err != nil {
+		scope.Err(err)
+		return
+	}
+
+	if deletedAt, ok := scope.FieldByName("DeletedAt"); ok && !scope.Search.Unscoped {
+		for _, row := range rows {
+			row[deletedAt.DBName] = gorm.NowFunc()
+		}
+	} else {
+		var kept []fakeRow
+
+		for _, row := range table.rows {
+			if !containsRow(rows, row) {
+				kept = append(kept, row)
+			}
+		}
+
+		table.rows = kept
+	}
+
+	f.noop.ReturnExecResult(0, int64(len(rows)))
+}
+
+// match returns the rows matching the scope's conditions. ORDER BY, LIMIT and
+// OFFSET are only applied if ordered is true. Callers must hold f's lock.
+func (f *FakeAdapter) match(scope *gorm.Scope, table *fakeTable, ordered bool) ([]fakeRow, error) {
+	// CombinedConditionSql adds the condition's args to SQLVars, which gorm
+	// does again when it builds the statement
+	sqlVars := scope.SQLVars
+	conditions := scope.CombinedConditionSql()
+	args := scope.SQLVars[len(sqlVars):]
+	scope.SQLVars = sqlVars
+
+	clauses, err := splitConditions(conditions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := parseCondition(clauses.where, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []fakeRow
+
+	for _, row := range table.rows {
+		if where(row) {
+			rows = append(rows, row)
+		}
+	}
+
+	if !ordered {
+		return rows, nil
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, order := range clauses.orders {
+			cmp, _ := compareValues(rows[i][order.column], rows[j][order.column])
+
+			if cmp != 0 {
+				return (cmp < 0) != order.desc
+			}
+		}
+
+		return false
+	})
+
+	if clauses.offset > 0 {
+		if clauses.offset >= len(rows) {
+			return nil, nil
+		}
+
+		rows = rows[clauses.offset:]
+	}
+
+	if clauses.limit >= 0 && clauses.limit < len(rows) {
+		rows = rows[:clauses.limit]
+	}
+
+	return rows, nil
+}
+
+// selectColumns returns the columns selected by the scope, or nil for
+// count(*)
+func selectColumns(scope *gorm.Scope, table *fakeTable) ([]string, error) {
+	attrs := scope.SelectAttrs()
+
+	if len(attrs) == 0 {
+		return table.columns, nil
+	}
+
+	var columns []string
+
+	for _, attr := range attrs {
+		for _, selected := range strings.Split(attr, ",") {
+			tokens, err := tokenize(selected)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(tokens) > 0 && tokens[0].is("count") {
+				return nil, nil
+			}
+
+			if len(tokens) == 1 && tokens[0].is("*") {
+				columns = append(columns, table.columns...)
+				continue
+			}
+
+			column, n := parseColumn(tokens)
+
+			if column == "" || n != len(tokens) {
+				return nil, fmt.Errorf("Unsupported select: %s", selected)
+			}
+
+			columns = append(columns, column)
+		}
+	}
+
+	return columns, nil
+}
+
+// parseColumn parses a column name, optionally prefixed with a table name,
+// from the start of tokens. It returns the column and the number of tokens
+// used.
+func parseColumn(tokens []token) (string, int) {
+	var column string
+	n := 0
+
+	for n < len(tokens) {
+		if tokens[n].kind != tokenWord && tokens[n].kind != tokenIdent {
+			break
+		}
+
+		column = tokens[n].text
+		n++
+
+		if n+1 < len(tokens) && tokens[n].is(".") {
+			n++
+			continue
+		}
+
+		break
+	}
+
+	return column, n
+}
+
+// parseDefaultValue converts a default from a sql or gorm tag to a value
+func parseDefaultValue(defaultValue string) driver.Value {
+	tokens, err := tokenize(defaultValue)
+
+	if err != nil || len(tokens) != 1 {
+		return defaultValue
+	}
+
+	switch tokens[0].kind {
+	case tokenString:
+		return tokens[0].text
+	case tokenNumber:
+		return parseNumber(tokens[0].text)
+	}
+
+	if tokens[0].is("null") {
+		return nil
+	}
+
+	return defaultValue
+}
+
+func parseNumber(text string) driver.Value {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i
+	}
+
+	f, _ := strconv.ParseFloat(text, 64)
+
+	return f
+}
+
+func (r fakeRow) copy() fakeRow {
+	copied := make(fakeRow, len(r))
+
+	for column, value := range r {
+		copied[column] = value
+	}
+
+	return copied
+}
+
+func containsRow(rows []fakeRow, row fakeRow) bool {
+	for _, r := range rows {
+		if reflect.ValueOf(r).Pointer() == reflect.ValueOf(row).Pointer() {
+			return true
+		}
+	}
+
+	return false
+}
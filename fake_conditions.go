@@ -0,0 +1,641 @@
+package gormexpect
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// fakeCondition reports whether a row matches a WHERE condition
+type fakeCondition func(row fakeRow) bool
+
+// fakeOperand returns the value of a column or literal for a row
+type fakeOperand func(row fakeRow) driver.Value
+
+// fakeExpr returns the value of an expression given with gorm.Expr for a row
+type fakeExpr func(row fakeRow) (driver.Value, error)
+
+type fakeOrder struct {
+	column string
+	desc   bool
+}
+
+// fakeClauses are the clauses of gorm's CombinedConditionSql
+type fakeClauses struct {
+	where  []token
+	orders []fakeOrder
+	limit  int
+	offset int
+}
+
+// splitConditions splits the output of CombinedConditionSql into its
+// clauses. Joins, GROUP BY and HAVING are not supported.
+func splitConditions(sql string) (fakeClauses, error) {
+	clauses := fakeClauses{limit: -1}
+	tokens, err := tokenize(sql)
+
+	if err != nil {
+		return clauses, err
+	}
+
+	var (
+		clause string
+		order  []token
+		depth  int
+	)
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		if depth == 0 && tok.kind == tokenWord {
+			keyword := strings.ToUpper(tok.text)
+
+			switch keyword {
+			case "WHERE", "LIMIT", "OFFSET":
+				clause = keyword
+				continue
+			case "ORDER":
+				if i+1 < len(tokens) && tokens[i+1].is("BY") {
+					clause = keyword
+					i++
+					continue
+				}
+			case "GROUP", "HAVING", "JOIN":
+				return clauses, fmt.Errorf("Unsupported %s in %s", keyword, sql)
+			}
+		}
+
+		switch {
+		case tok.is("("):
+			depth++
+		case tok.is(")"):
+			depth--
+		}
+
+		switch clause {
+		case "WHERE":
+			clauses.where = append(clauses.where, tok)
+		case "ORDER":
+			order = append(order, tok)
+		case "LIMIT", "OFFSET":
+			if tok.kind != tokenNumber {
+				return clauses, fmt.Errorf("Unsupported %s in %s", clause, sql)
+			}
+
+			n, _ := strconv.Atoi(tok.text)
+
+			if clause == "LIMIT" {
+				clauses.limit = n
+			} else {
+				clauses.offset = n
+			}
+		default:
+			return clauses, fmt.Errorf("Unsupported condition: %s", sql)
+		}
+	}
+
+	clauses.orders, err = parseOrders(order)
+
+	return clauses, err
+}
+
+// parseOrders parses the columns of an ORDER BY clause
+func parseOrders(tokens []token) ([]fakeOrder, error) {
+	var orders []fakeOrder
+
+	for len(tokens) > 0 {
+		column, n := parseColumn(tokens)
+
+		if column == "" {
+			return nil, fmt.Errorf("Unsupported ORDER BY at %q", tokens[0].text)
+		}
+
+		order := fakeOrder{column: column}
+		tokens = tokens[n:]
+
+		if len(tokens) > 0 && (tokens[0].is("ASC") || tokens[0].is("DESC")) {
+			order.desc = tokens[0].is("DESC")
+			tokens = tokens[1:]
+		}
+
+		if len(tokens) > 0 {
+			if !tokens[0].is(",") {
+				return nil, fmt.Errorf("Unsupported ORDER BY at %q", tokens[0].text)
+			}
+
+			tokens = tokens[1:]
+		}
+
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// conditionParser is a recursive descent parser for WHERE conditions.
+// Placeholders take their values from args, in order.
+type conditionParser struct {
+	tokens []token
+	pos    int
+	args   []interface{}
+	arg    int
+}
+
+// parseCondition parses a WHERE condition. An empty condition matches every
+// row.
+func parseCondition(tokens []token, args []interface{}) (fakeCondition, error) {
+	if len(tokens) == 0 {
+		return func(row fakeRow) bool { return true }, nil
+	}
+
+	p := &conditionParser{tokens: tokens, args: args}
+	condition, err := p.parseOr()
+
+	if err == nil && p.pos < len(p.tokens) {
+		err = p.errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return condition, err
+}
+
+func (p *conditionParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].is(text)
+}
+
+func (p *conditionParser) accept(text string) bool {
+	if p.peek(text) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *conditionParser) errorf(format string, args ...interface{}) error {
+	var sql bytes.Buffer
+
+	for i, tok := range p.tokens {
+		if i > 0 {
+			sql.WriteString(" ")
+		}
+
+		sql.WriteString(tok.text)
+	}
+
+	return fmt.Errorf("Unsupported condition %s: %s", sql.String(), fmt.Sprintf(format, args...))
+}
+
+func (p *conditionParser) parseOr() (fakeCondition, error) {
+	left, err := p.parseAnd()
+
+	for err == nil && p.accept("OR") {
+		var right fakeCondition
+
+		if right, err = p.parseAnd(); err == nil {
+			l := left
+			left = func(row fakeRow) bool { return l(row) || right(row) }
+		}
+	}
+
+	return left, err
+}
+
+func (p *conditionParser) parseAnd() (fakeCondition, error) {
+	left, err := p.parseNot()
+
+	for err == nil && p.accept("AND") {
+		var right fakeCondition
+
+		if right, err = p.parseNot(); err == nil {
+			l := left
+			left = func(row fakeRow) bool { return l(row) && right(row) }
+		}
+	}
+
+	return left, err
+}
+
+func (p *conditionParser) parseNot() (fakeCondition, error) {
+	if p.accept("NOT") {
+		condition, err := p.parseNot()
+
+		return func(row fakeRow) bool { return !condition(row) }, err
+	}
+
+	if p.peek("(") && !p.isParenthesizedOperand() {
+		p.pos++
+		condition, err := p.parseOr()
+
+		if err == nil && !p.accept(")") {
+			err = p.errorf("missing )")
+		}
+
+		return condition, err
+	}
+
+	return p.parseComparison()
+}
+
+// isParenthesizedOperand returns true if the ( at pos starts a value, like
+// (1) = id, rather than a condition
+func (p *conditionParser) isParenthesizedOperand() bool {
+	return p.pos+2 < len(p.tokens) && p.tokens[p.pos+2].is(")") && p.tokens[p.pos+1].kind != tokenWord && p.tokens[p.pos+1].kind != tokenIdent
+}
+
+func (p *conditionParser) parseComparison() (fakeCondition, error) {
+	left, err := p.parseOperand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("IS") {
+		not := p.accept("NOT")
+
+		if !p.accept("NULL") {
+			return nil, p.errorf("expected NULL after IS")
+		}
+
+		return func(row fakeRow) bool { return (left(row) == nil) != not }, nil
+	}
+
+	not := p.accept("NOT")
+
+	switch {
+	case p.accept("IN"):
+		if !p.accept("(") {
+			return nil, p.errorf("expected ( after IN")
+		}
+
+		var values []fakeOperand
+
+		for !p.accept(")") {
+			value, err := p.parseOperand()
+
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+
+			if !p.peek(")") && !p.accept(",") {
+				return nil, p.errorf("expected , in IN")
+			}
+		}
+
+		return func(row fakeRow) bool {
+			l := left(row)
+
+			if l == nil {
+				return false
+			}
+
+			for _, value := range values {
+				if cmp, ok := compareValues(l, value(row)); ok && cmp == 0 {
+					return !not
+				}
+			}
+
+			return not
+		}, nil
+	case p.accept("LIKE"):
+		pattern, err := p.parseOperand()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(row fakeRow) bool {
+			l, r := left(row), pattern(row)
+
+			if l == nil || r == nil {
+				return false
+			}
+
+			return likeRegexp(fmt.Sprint(asString(r))).MatchString(fmt.Sprint(asString(l))) != not
+		}, nil
+	case not:
+		return nil, p.errorf("expected IN or LIKE after NOT")
+	}
+
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOperator {
+		return nil, p.errorf("expected a comparison")
+	}
+
+	op := p.tokens[p.pos].text
+	p.pos++
+
+	right, err := p.parseOperand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var matches func(cmp int) bool
+
+	switch op {
+	case "=":
+		matches = func(cmp int) bool { return cmp == 0 }
+	case "<>", "!=":
+		matches = func(cmp int) bool { return cmp != 0 }
+	case "<":
+		matches = func(cmp int) bool { return cmp < 0 }
+	case "<=":
+		matches = func(cmp int) bool { return cmp <= 0 }
+	case ">":
+		matches = func(cmp int) bool { return cmp > 0 }
+	case ">=":
+		matches = func(cmp int) bool { return cmp >= 0 }
+	default:
+		return nil, p.errorf("unsupported operator %s", op)
+	}
+
+	return func(row fakeRow) bool {
+		cmp, ok := compareValues(left(row), right(row))
+
+		return ok && matches(cmp)
+	}, nil
+}
+
+func (p *conditionParser) parseOperand() (fakeOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("unexpected end")
+	}
+
+	tok := p.tokens[p.pos]
+
+	switch tok.kind {
+	case tokenPlaceholder:
+		if p.arg >= len(p.args) {
+			return nil, p.errorf("not enough args")
+		}
+
+		value, err := getFieldValue(reflect.ValueOf(p.args[p.arg]))
+
+		if err != nil {
+			return nil, p.errorf("cannot compare %T", p.args[p.arg])
+		}
+
+		p.pos++
+		p.arg++
+
+		return func(fakeRow) driver.Value { return value }, nil
+	case tokenNumber:
+		p.pos++
+		value := parseNumber(tok.text)
+
+		return func(fakeRow) driver.Value { return value }, nil
+	case tokenString:
+		p.pos++
+
+		return func(fakeRow) driver.Value { return tok.text }, nil
+	case tokenWord, tokenIdent:
+		if tok.kind == tokenWord {
+			switch strings.ToUpper(tok.text) {
+			case "NULL":
+				p.pos++
+				return func(fakeRow) driver.Value { return nil }, nil
+			case "TRUE", "FALSE":
+				p.pos++
+				value := strings.EqualFold(tok.text, "TRUE")
+				return func(fakeRow) driver.Value { return value }, nil
+			}
+		}
+
+		column, n := parseColumn(p.tokens[p.pos:])
+
+		if p.pos+n < len(p.tokens) && p.tokens[p.pos+n].is("(") {
+			return nil, p.errorf("unsupported function %s", column)
+		}
+
+		p.pos += n
+
+		return func(row fakeRow) driver.Value { return row[column] }, nil
+	case tokenPunct:
+		if tok.is("(") {
+			p.pos++
+			operand, err := p.parseOperand()
+
+			if err == nil && !p.accept(")") {
+				err = p.errorf("missing )")
+			}
+
+			return operand, err
+		}
+	case tokenOperator:
+		if tok.is("-") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == tokenNumber {
+			p.pos += 2
+			value := parseNumber("-" + p.tokens[p.pos-1].text)
+
+			return func(fakeRow) driver.Value { return value }, nil
+		}
+	}
+
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+// parseExpr parses a gorm.Expr used as an update value. Operands, such as
+// columns and args, and arithmetic on two of them, e.g. age + ?, are
+// supported. Anything else, like function calls, is an error. gorm writes the
+// expression and collects its args in a new scope, as it would when building
+// the UPDATE.
+func parseExpr(scope *gorm.Scope, expr *gorm.SqlExpr) (fakeExpr, error) {
+	exprScope := scope.New(nil)
+	sql := strings.Replace(exprScope.AddToVars(expr), "$$$", "?", -1)
+	args := exprScope.SQLVars
+
+	tokens, err := tokenize(sql)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported expression %s: %s", sql, err)
+	}
+
+	p := &conditionParser{tokens: tokens, args: args}
+	left, err := p.parseOperand()
+
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported expression %s", sql)
+	}
+
+	if p.pos == len(tokens) {
+		return func(row fakeRow) (driver.Value, error) { return left(row), nil }, nil
+	}
+
+	operator := tokens[p.pos].text
+	p.pos++
+
+	switch operator {
+	case "+", "-", "*", "/":
+	default:
+		return nil, fmt.Errorf("Unsupported expression %s", sql)
+	}
+
+	right, err := p.parseOperand()
+
+	if err != nil || p.pos != len(tokens) {
+		return nil, fmt.Errorf("Unsupported expression %s", sql)
+	}
+
+	return func(row fakeRow) (driver.Value, error) {
+		return arithmetic(left(row), operator, right(row))
+	}, nil
+}
+
+// arithmetic applies +, -, * or / to two numbers. Integers stay integers,
+// like they do in SQL, and NULL operands give NULL.
+func arithmetic(a driver.Value, operator string, b driver.Value) (driver.Value, error) {
+	if a == nil || b == nil {
+		return nil, nil
+	}
+
+	ai, aInt := a.(int64)
+	bi, bInt := b.(int64)
+
+	if aInt && bInt {
+		switch operator {
+		case "+":
+			return ai + bi, nil
+		case "-":
+			return ai - bi, nil
+		case "*":
+			return ai * bi, nil
+		case "/":
+			if bi == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+
+			return ai / bi, nil
+		}
+	}
+
+	af, aOk := asFloat(a)
+	bf, bOk := asFloat(b)
+
+	if !aOk || !bOk {
+		return nil, fmt.Errorf("cannot apply %s to %T and %T", operator, a, b)
+	}
+
+	switch operator {
+	case "+":
+		return af + bf, nil
+	case "-":
+		return af - bf, nil
+	case "*":
+		return af * bf, nil
+	}
+
+	if bf == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+
+	return af / bf, nil
+}
+
+// compareValues compares two driver values. false is returned if either is
+// NULL, or if they cannot be compared.
+func compareValues(a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	a, b = asString(a), asString(b)
+
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			switch {
+			case ai < bi:
+				return -1, true
+			case ai > bi:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+
+	if af, ok := asFloat(a); ok {
+		if bf, ok := asFloat(b); ok {
+			switch {
+			case af < bf:
+				return -1, true
+			case af > bf:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+
+	switch av := a.(type) {
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1, true
+			case av.After(bv):
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func asString(value driver.Value) driver.Value {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+
+	return value
+}
+
+func asFloat(value driver.Value) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// likeRegexp converts a LIKE pattern to a regexp
+func likeRegexp(pattern string) *regexp.Regexp {
+	var re bytes.Buffer
+	re.WriteString("(?s)^")
+
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	re.WriteString("$")
+
+	return regexp.MustCompile(re.String())
+}
@@ -0,0 +1,143 @@
+package gormexpect_test
+
+import (
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeCreateFind(t *testing.T) {
+	db, expect, err := expecter.NewExpecter(expecter.NewFakeAdapter, "sqlmock")
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jinzhu := User{Name: "jinzhu", Age: 18}
+	itwx := User{Name: "itwx", Age: 30}
+
+	assert.Nil(t, db.Create(&jinzhu).Error)
+	assert.Nil(t, db.Create(&itwx).Error)
+	assert.Equal(t, int64(1), jinzhu.Id)
+	assert.Equal(t, int64(2), itwx.Id)
+
+	// defaults are set like a real database would
+	assert.Equal(t, "Tech in Asia", jinzhu.Company)
+
+	var found User
+	assert.Nil(t, db.First(&found, itwx.Id).Error)
+	assert.Equal(t, "itwx", found.Name)
+
+	var users []User
+	assert.Nil(t, db.Where("age > ?", 20).Or("name IN (?)", []string{"jinzhu"}).Order("age desc").Find(&users).Error)
+	assert.Equal(t, 2, len(users))
+	assert.Equal(t, "itwx", users[0].Name)
+
+	var count int
+	assert.Nil(t, db.Model(&User{}).Where("name LIKE ?", "jin%").Count(&count).Error)
+	assert.Equal(t, 1, count)
+
+	assert.True(t, db.Where(&User{Name: "nobody"}).First(&found).RecordNotFound())
+	assert.NotNil(t, db.Create(&User{Id: 1}).Error)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestFakeUpdateDelete(t *testing.T) {
+	db, _, err := expecter.NewExpecter(expecter.NewFakeAdapter, "sqlmock")
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Name: "jinzhu"}
+	db.Create(&user)
+
+	updated := db.Model(&user).Update("name", "uhznij")
+	assert.Nil(t, updated.Error)
+	assert.Equal(t, int64(1), updated.RowsAffected)
+
+	var found User
+	db.First(&found, user.Id)
+	assert.Equal(t, "uhznij", found.Name)
+
+	found.Age = 20
+	assert.Nil(t, db.Save(&found).Error)
+	db.First(&found, user.Id)
+	assert.Equal(t, int64(20), found.Age)
+
+	deleted := db.Delete(&found)
+	assert.Nil(t, deleted.Error)
+	assert.Equal(t, int64(1), deleted.RowsAffected)
+	assert.True(t, db.First(&found, user.Id).RecordNotFound())
+}
+
+func TestFakeSoftDelete(t *testing.T) {
+	db, _, err := expecter.NewExpecter(expecter.NewFakeAdapter, "sqlmock")
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	card := CreditCard{Number: "4242"}
+	db.Create(&card)
+	db.Delete(&card)
+
+	var found CreditCard
+	assert.True(t, db.First(&found, card.ID).RecordNotFound())
+	assert.Nil(t, db.Unscoped().First(&found, card.ID).Error)
+	assert.NotNil(t, found.DeletedAt)
+}
+
+func TestFakePreload(t *testing.T) {
+	db, _, err := expecter.NewExpecter(expecter.NewFakeAdapter, "sqlmock")
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Name: "jinzhu", Emails: []Email{Email{Email: "a@example.org"}, Email{Email: "b@example.org"}}}
+	assert.Nil(t, db.Create(&user).Error)
+
+	var found User
+	assert.Nil(t, db.Preload("Emails").First(&found, user.Id).Error)
+	assert.Equal(t, 2, len(found.Emails))
+	assert.Equal(t, "b@example.org", found.Emails[1].Email)
+}
+
+func TestFakeUpdateExpr(t *testing.T) {
+	db, _, err := expecter.NewExpecter(expecter.NewFakeAdapter, "sqlmock")
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Name: "jinzhu", Age: 18}
+	db.Create(&user)
+
+	assert.Nil(t, db.Model(&user).UpdateColumn("age", gorm.Expr("age + ?", 2)).Error)
+
+	var found User
+	db.First(&found, user.Id)
+	assert.Equal(t, int64(20), found.Age)
+
+	// nested expressions are expanded by gorm
+	assert.Nil(t, db.Model(&user).UpdateColumn("age", gorm.Expr("age * ?", gorm.Expr("?", 2))).Error)
+	db.First(&found, user.Id)
+	assert.Equal(t, int64(40), found.Age)
+
+	err = db.Model(&user).UpdateColumn("name", gorm.Expr("concat(name, ?)", " jr")).Error
+
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Unsupported expression concat(name, ?)")
+	}
+
+	db.First(&found, user.Id)
+	assert.Equal(t, "jinzhu", found.Name)
+}
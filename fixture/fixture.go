@@ -0,0 +1,364 @@
+// Package fixture loads YAML or JSON fixture files into gorm models, so that
+// the values passed to Returns can be shared across tests.
+//
+// A fixture file maps table names to named records. Plain fields are given
+// by column or field name. Association fields refer to records in other
+// tables by key, or describe them inline:
+//
+//	users:
+//	  jinzhu:
+//	    id: 1
+//	    name: jinzhu
+//	    emails: [jinzhu_work]
+//	    credit_card: {number: "4111111111111111"}
+//	emails:
+//	  jinzhu_work:
+//	    id: 1
+//	    email: jinzhu@example.org
+//
+// Foreign keys of associated records are set from their parent, the same way
+// gorm would when saving them. Since JSON is valid YAML, JSON files are loaded
+// the same way.
+package fixture
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/iantanwx/gorm-expect/internal/relation"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures holds the records loaded from one or more fixture files
+type Fixtures struct {
+	db     *gorm.DB
+	tables map[string]*table
+}
+
+// table holds the records of a single table, in the order they were defined
+type table struct {
+	keys    []string
+	records map[string]*yaml.Node
+}
+
+// Load reads fixture files. db is only used to inspect models (table names,
+// columns and associations), so the gorm DB returned by NewDefaultExpecter
+// can be passed in.
+func Load(db *gorm.DB, paths ...string) (*Fixtures, error) {
+	f := &Fixtures{db: db, tables: map[string]*table{}}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := f.parse(path, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Parse reads fixtures from YAML or JSON data
+func Parse(db *gorm.DB, data []byte) (*Fixtures, error) {
+	f := &Fixtures{db: db, tables: map[string]*table{}}
+
+	if err := f.parse("fixture", data); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *Fixtures) parse(name string, data []byte) error {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: expected a mapping of table names to records", name)
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		tableName, records := root.Content[i].Value, root.Content[i+1]
+
+		if records.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s: expected a mapping of keys to records for table %s", name, tableName)
+		}
+
+		t, ok := f.tables[tableName]
+
+		if !ok {
+			t = &table{records: map[string]*yaml.Node{}}
+			f.tables[tableName] = t
+		}
+
+		for j := 0; j < len(records.Content); j += 2 {
+			key, record := records.Content[j].Value, records.Content[j+1]
+
+			if _, exists := t.records[key]; exists {
+				return fmt.Errorf("%s: duplicate record %s.%s", name, tableName, key)
+			}
+
+			if record.Kind != yaml.MappingNode {
+				return fmt.Errorf("%s: record %s.%s should be a mapping", name, tableName, key)
+			}
+
+			t.keys = append(t.keys, key)
+			t.records[key] = record
+		}
+	}
+
+	return nil
+}
+
+// Get fills out with fixture records. out must be a pointer to a struct,
+// in which case exactly one key must be given, or a pointer to a slice, which
+// is filled with the given records, or every record of the table if no keys
+// are given. The table is the one gorm would use for out.
+func (f *Fixtures) Get(out interface{}, keys ...string) error {
+	outVal := reflect.ValueOf(out)
+
+	if outVal.Kind() != reflect.Ptr || outVal.IsNil() {
+		return fmt.Errorf("fixture: out should be a non-nil pointer, got %T", out)
+	}
+
+	outVal = outVal.Elem()
+	tableName := f.db.NewScope(out).TableName()
+	t, ok := f.tables[tableName]
+
+	if !ok {
+		return fmt.Errorf("fixture: no records for table %s", tableName)
+	}
+
+	if outVal.Kind() != reflect.Slice {
+		if len(keys) != 1 {
+			return fmt.Errorf("fixture: expected exactly one key for %T, got %d", out, len(keys))
+		}
+
+		return f.build(outVal, tableName, keys[0], map[string]bool{})
+	}
+
+	if len(keys) == 0 {
+		keys = t.keys
+	}
+
+	elemType := outVal.Type().Elem()
+	slice := reflect.MakeSlice(outVal.Type(), 0, len(keys))
+
+	for _, key := range keys {
+		elem, err := f.newRecord(elemType, tableName, key, map[string]bool{})
+
+		if err != nil {
+			return err
+		}
+
+		slice = reflect.Append(slice, elem)
+	}
+
+	outVal.Set(slice)
+
+	return nil
+}
+
+// Must is like Get, but panics on error and returns out, so that fixtures
+// can be passed to Returns directly:
+//
+//	expect.First(&user).Returns(fixtures.Must(&User{}, "jinzhu"))
+func (f *Fixtures) Must(out interface{}, keys ...string) interface{} {
+	if err := f.Get(out, keys...); err != nil {
+		panic(err)
+	}
+
+	return out
+}
+
+// newRecord builds a record into a new value of typ, which may be a struct
+// or a pointer to one
+func (f *Fixtures) newRecord(typ reflect.Type, tableName, key string, building map[string]bool) (reflect.Value, error) {
+	structType := typ
+
+	if typ.Kind() == reflect.Ptr {
+		structType = typ.Elem()
+	}
+
+	ptr := reflect.New(structType)
+
+	if err := f.build(ptr.Elem(), tableName, key, building); err != nil {
+		return reflect.Value{}, err
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		return ptr, nil
+	}
+
+	return ptr.Elem(), nil
+}
+
+// build decodes the record tableName.key into dest
+func (f *Fixtures) build(dest reflect.Value, tableName, key string, building map[string]bool) error {
+	t, ok := f.tables[tableName]
+
+	if !ok {
+		return fmt.Errorf("fixture: no records for table %s", tableName)
+	}
+
+	record, ok := t.records[key]
+
+	if !ok {
+		return fmt.Errorf("fixture: no record %s.%s", tableName, key)
+	}
+
+	// a record that refers back to one of its ancestors is left blank, or
+	// associations that point both ways would never terminate
+	id := tableName + "." + key
+	building[id] = true
+	defer delete(building, id)
+
+	return f.decode(dest, record, id, building)
+}
+
+// decode fills dest from a record mapping. name is used in error messages.
+// Plain fields are decoded before associations, so that keys are known when
+// foreign keys are filled in, whatever order the record lists them in.
+func (f *Fixtures) decode(dest reflect.Value, record *yaml.Node, name string, building map[string]bool) error {
+	scope := f.db.NewScope(dest.Addr().Interface())
+
+	var associations []int
+
+	for i := 0; i < len(record.Content); i += 2 {
+		column, node := record.Content[i].Value, record.Content[i+1]
+		field, ok := relation.FindField(scope, column)
+
+		if !ok {
+			return fmt.Errorf("fixture: %s: %s has no field %s", name, dest.Type(), column)
+		}
+
+		if field.Relationship != nil {
+			associations = append(associations, i)
+			continue
+		}
+
+		if err := decodeField(field, node); err != nil {
+			return fmt.Errorf("fixture: %s: field %s: %s", name, field.Name, err)
+		}
+	}
+
+	for _, i := range associations {
+		field, _ := relation.FindField(scope, record.Content[i].Value)
+
+		if err := f.decodeAssociation(scope, field, record.Content[i+1], name, building); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeAssociation fills an association field from a key, an inline record,
+// or a list of either
+func (f *Fixtures) decodeAssociation(scope *gorm.Scope, field *gorm.Field, node *yaml.Node, name string, building map[string]bool) error {
+	relatedTable := scope.New(reflect.New(relation.IndirectType(field.Struct.Type)).Interface()).TableName()
+
+	if node.Kind == yaml.SequenceNode {
+		if field.Field.Kind() != reflect.Slice {
+			return fmt.Errorf("fixture: %s: field %s is not a slice", name, field.Name)
+		}
+
+		elemType := field.Field.Type().Elem()
+		slice := reflect.MakeSlice(field.Field.Type(), 0, len(node.Content))
+
+		for _, item := range node.Content {
+			elem, ok, err := f.decodeRelated(elemType, relatedTable, item, name, building)
+
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				relation.SetForeignKeys(scope, field, elem)
+				slice = reflect.Append(slice, elem)
+			}
+		}
+
+		field.Field.Set(slice)
+
+		return nil
+	}
+
+	if field.Field.Kind() == reflect.Slice {
+		return fmt.Errorf("fixture: %s: field %s should be a list", name, field.Name)
+	}
+
+	elem, ok, err := f.decodeRelated(field.Field.Type(), relatedTable, node, name, building)
+
+	if err != nil || !ok {
+		return err
+	}
+
+	relation.SetForeignKeys(scope, field, elem)
+	field.Field.Set(elem)
+
+	return nil
+}
+
+// decodeRelated builds an associated record, which is either a key into
+// relatedTable or an inline mapping. ok is false if the key refers to a
+// record that is still being built.
+func (f *Fixtures) decodeRelated(typ reflect.Type, relatedTable string, node *yaml.Node, name string, building map[string]bool) (reflect.Value, bool, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if building[relatedTable+"."+node.Value] {
+			return reflect.Value{}, false, nil
+		}
+
+		elem, err := f.newRecord(typ, relatedTable, node.Value, building)
+
+		return elem, err == nil, err
+	case yaml.MappingNode:
+		ptr := reflect.New(relation.IndirectType(typ))
+
+		if err := f.decode(ptr.Elem(), node, name, building); err != nil {
+			return reflect.Value{}, false, err
+		}
+
+		if typ.Kind() == reflect.Ptr {
+			return ptr, true, nil
+		}
+
+		return ptr.Elem(), true, nil
+	default:
+		return reflect.Value{}, false, fmt.Errorf("fixture: %s: expected a key or a record at line %d", name, node.Line)
+	}
+}
+
+// decodeField decodes a scalar into a plain field. Fields implementing
+// sql.Scanner are scanned from the decoded value, as they would be from a
+// database row.
+func decodeField(field *gorm.Field, node *yaml.Node) error {
+	if _, ok := field.Field.Addr().Interface().(sql.Scanner); ok {
+		var value interface{}
+
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+
+		return field.Set(value)
+	}
+
+	return node.Decode(field.Field.Addr().Interface())
+}
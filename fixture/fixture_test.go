@@ -0,0 +1,182 @@
+package fixture_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/iantanwx/gorm-expect/fixture"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+type User struct {
+	Id         int64
+	Name       string
+	Birthday   *time.Time
+	Emails     []Email
+	CreditCard CreditCard
+	Languages  []Language `gorm:"many2many:user_languages;"`
+}
+
+type Email struct {
+	Id       int16
+	UserId   int
+	Email    string
+	Verified bool
+}
+
+type CreditCard struct {
+	ID     int
+	Number string
+	UserId sql.NullInt64
+}
+
+type Language struct {
+	gorm.Model
+	Name  string
+	Users []User `gorm:"many2many:user_languages;"`
+}
+
+type Order struct {
+	Id     int64
+	UserId int64
+	User   User
+	Price  float64
+}
+
+func TestGet(t *testing.T) {
+	db, _, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := fixture.Load(db, "testdata/users.yml", "testdata/orders.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+
+	if err := fixtures.Get(&user, "jinzhu"); err != nil {
+		t.Fatal(err)
+	}
+
+	birthday := time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, int64(1), user.Id)
+	assert.Equal(t, &birthday, user.Birthday)
+	assert.Equal(t, []Email{
+		Email{Id: 1, UserId: 1, Email: "jinzhu@example.org", Verified: true},
+		Email{Id: 2, UserId: 1, Email: "jinzhu@example.com"},
+	}, user.Emails)
+	assert.Equal(t, CreditCard{ID: 1, Number: "4111111111111111", UserId: sql.NullInt64{Int64: 1, Valid: true}}, user.CreditCard)
+
+	// the user refers to en, which refers back to the user
+	assert.Equal(t, "English", user.Languages[0].Name)
+	assert.Empty(t, user.Languages[0].Users)
+
+	var order Order
+
+	if err := fixtures.Get(&order, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int64(2), order.UserId)
+	assert.Equal(t, "itwx", order.User.Name)
+	assert.Equal(t, []Email{Email{UserId: 2, Email: "itwx@example.org"}}, order.User.Emails)
+
+	var users []User
+
+	if err := fixtures.Get(&users); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, users, 2)
+	assert.Equal(t, "jinzhu", users[0].Name)
+	assert.Equal(t, "itwx", users[1].Name)
+}
+
+func TestGetAssociationsFirst(t *testing.T) {
+	db, _, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the emails are listed before the id they need as a foreign key
+	fixtures, err := fixture.Parse(db, []byte(`
+users:
+  jinzhu:
+    emails:
+      - email: jinzhu@example.org
+    credit_card:
+      number: "4111111111111111"
+    id: 7
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+
+	if err := fixtures.Get(&user, "jinzhu"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []Email{Email{UserId: 7, Email: "jinzhu@example.org"}}, user.Emails)
+	assert.Equal(t, sql.NullInt64{Int64: 7, Valid: true}, user.CreditCard.UserId)
+}
+
+func TestGetErrors(t *testing.T) {
+	db, _, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := fixture.Parse(db, []byte("users:\n  jinzhu:\n    nickname: jinzhu\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.EqualError(t, fixtures.Get(&User{}, "jinzhu"), "fixture: users.jinzhu: fixture_test.User has no field nickname")
+	assert.EqualError(t, fixtures.Get(&User{}, "itwx"), "fixture: no record users.itwx")
+	assert.EqualError(t, fixtures.Get(&Order{}, "first"), "fixture: no records for table orders")
+
+	_, err = fixture.Load(db, "testdata/users.yml", "testdata/users.yml")
+	assert.EqualError(t, err, "testdata/users.yml: duplicate record users.jinzhu")
+}
+
+func TestReturns(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := fixture.Load(db, "testdata/users.yml")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := fixtures.Must(&User{}, "jinzhu").(*User)
+
+	expect.Preload("Emails").Preload("CreditCard").Find(&in).Returns(out)
+	db.Preload("Emails").Preload("CreditCard").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out.Emails, in.Emails)
+	assert.Equal(t, out.CreditCard, in.CreditCard)
+}
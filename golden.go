@@ -0,0 +1,149 @@
+package gormexpect
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updating returns true if the test binary defines an -update flag and it is
+// set, as in go test -update, or if GORMEXPECT_UPDATE is set to a true value.
+// Golden files and replay fixtures are then written instead of being compared
+// against. The flag is left for tests to define, so that it can't clash with
+// one of their own:
+//
+//	var _ = flag.Bool("update", false, "update golden files")
+func updating() bool {
+	if f := flag.Lookup("update"); f != nil {
+		if update, _ := strconv.ParseBool(f.Value.String()); update {
+			return true
+		}
+	}
+
+	update, _ := strconv.ParseBool(os.Getenv("GORMEXPECT_UPDATE"))
+
+	return update
+}
+
+// GoldenOption changes where AssertGolden keeps golden files, or whether it
+// writes them
+type GoldenOption func(*goldenOptions)
+
+type goldenOptions struct {
+	dir    string
+	update bool
+}
+
+// GoldenDir keeps golden files in dir instead of testdata
+func GoldenDir(dir string) GoldenOption {
+	return func(o *goldenOptions) {
+		o.dir = dir
+	}
+}
+
+// GoldenUpdate writes the golden file if update is true, and compares against
+// it otherwise, whatever -update or GORMEXPECT_UPDATE are set to
+func GoldenUpdate(update bool) GoldenOption {
+	return func(o *goldenOptions) {
+		o.update = update
+	}
+}
+
+// AssertGolden compares the statements recorded so far (as written by Dump)
+// with testdata/<name>.golden. Times change every run, e.g. in CreatedAt, so
+// time args are written as <time>. With go test -update, or if
+// GORMEXPECT_UPDATE is set, the golden file is written instead.
+func (h *Expecter) AssertGolden(name string, opts ...GoldenOption) error {
+	o := &goldenOptions{dir: "testdata", update: updating()}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	path := filepath.Join(o.dir, name+".golden")
+	recorded := goldenDump(h.Recorded())
+
+	if o.update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(path, []byte(recorded), 0644)
+	}
+
+	golden, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return fmt.Errorf("Could not read golden file (run go test -update to create it): %s", err)
+	}
+
+	if string(golden) == recorded {
+		return nil
+	}
+
+	return fmt.Errorf("Recorded statements do not match %s:\n%s", path, diffLines(string(golden), recorded))
+}
+
+// goldenDump formats statements like Dump, with a placeholder for times
+func goldenDump(stmts []Statement) string {
+	dump := bytes.NewBufferString("")
+
+	for i, stmt := range stmts {
+		args := make([]interface{}, len(stmt.Args))
+
+		for j, arg := range stmt.Args {
+			switch t := arg.(type) {
+			case time.Time:
+				arg = goldenTime{}
+			case *time.Time:
+				if t != nil {
+					arg = goldenTime{}
+				}
+			}
+
+			args[j] = arg
+		}
+
+		stmt.Args = args
+		fmt.Fprintf(dump, "%d. %s\n", i+1, stmt)
+	}
+
+	return dump.String()
+}
+
+// goldenTime stands in for a time in golden files
+type goldenTime struct{}
+
+func (goldenTime) String() string {
+	return "<time>"
+}
+
+// diffLines describes the first line where expected and actual differ
+func diffLines(expected, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var want, got string
+
+		if i < len(expectedLines) {
+			want = expectedLines[i]
+		}
+
+		if i < len(actualLines) {
+			got = actualLines[i]
+		}
+
+		if want != got {
+			return fmt.Sprintf("line %d:\n  golden:   %s\n  recorded: %s", i+1, want, got)
+		}
+	}
+
+	return ""
+}
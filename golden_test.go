@@ -0,0 +1,121 @@
+package gormexpect_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+)
+
+// update is read by AssertGolden, so that go test -update rewrites the
+// golden files
+var update = flag.Bool("update", false, "update golden files")
+
+func TestAssertGolden(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{
+		Id:         1,
+		Emails:     []Email{Email{Id: 1, UserId: 1}},
+		CreditCard: CreditCard{ID: 1},
+	}
+
+	expect.Preload("Emails").Preload("CreditCard").Find(&in).Returns(out)
+	db.Preload("Emails").Preload("CreditCard").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, expect.AssertGolden("preload_user"))
+}
+
+func TestAssertGoldenMismatch(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// compare against a copy, so that updating golden files never touches
+	// the shared one
+	dir := t.TempDir()
+	golden, err := ioutil.ReadFile(filepath.Join("testdata", "preload_user.golden"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "preload_user.golden"), golden, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Emails: []Email{Email{Id: 1, UserId: 1}}}
+
+	expect.Preload("Emails").Find(&in).Returns(out)
+	db.Preload("Emails").Find(&in)
+
+	err = expect.AssertGolden("preload_user", expecter.GoldenDir(dir), expecter.GoldenUpdate(false))
+
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "line 3:")
+	}
+
+	assert.NotNil(t, expect.AssertGolden("does_not_exist", expecter.GoldenDir(dir), expecter.GoldenUpdate(false)))
+}
+
+func TestAssertGoldenUpdateFlag(t *testing.T) {
+	if *update {
+		t.Skip("golden files are already being updated")
+	}
+
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	expect.First(&User{Id: 1})
+
+	assert.NotNil(t, expect.AssertGolden("first_user", expecter.GoldenDir(dir)))
+
+	flag.Set("update", "true")
+	defer flag.Set("update", "false")
+
+	assert.Nil(t, expect.AssertGolden("first_user", expecter.GoldenDir(dir)))
+	assert.FileExists(t, filepath.Join(dir, "first_user.golden"))
+}
+
+func TestAssertGoldenTimes(t *testing.T) {
+	dir := t.TempDir()
+
+	// CreatedAt and UpdatedAt are different on every run
+	for _, update := range []bool{true, false} {
+		db, expect, err := expecter.NewDefaultExpecter()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user := User{Name: "jinzhu"}
+		expect.Create(&user)
+
+		assert.Nil(t, expect.AssertGolden("create_user", expecter.GoldenDir(dir), expecter.GoldenUpdate(update)))
+		db.Close()
+	}
+
+	golden, err := ioutil.ReadFile(filepath.Join(dir, "create_user.golden"))
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(golden), "<time>")
+}
@@ -0,0 +1,90 @@
+// Package relation fills in the keys between associated gorm models, for the
+// packages that build models without saving them.
+package relation
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SetForeignKeys sets the foreign keys between the record in scope and an
+// associated record, as gorm does when saving associations: keys that are
+// already set are overwritten, and so is the type of a polymorphic
+// association. Blank source keys are skipped.
+func SetForeignKeys(scope *gorm.Scope, field *gorm.Field, elem reflect.Value) {
+	// scopes cache whether their fields are blank, so start from a new one
+	scope = scope.New(scope.Value)
+	rel := field.Relationship
+	related := scope.New(addr(elem).Interface())
+
+	switch rel.Kind {
+	case "has_one", "has_many":
+		copyKeys(scope, rel.AssociationForeignFieldNames, related, rel.ForeignFieldNames)
+
+		if rel.PolymorphicType != "" {
+			if typeField, ok := related.FieldByName(rel.PolymorphicType); ok {
+				typeField.Set(rel.PolymorphicValue)
+			}
+		}
+	case "belongs_to":
+		copyKeys(related, rel.AssociationForeignFieldNames, scope, rel.ForeignFieldNames)
+	}
+}
+
+// copyKeys copies the named fields of from into the named fields of to
+func copyKeys(from *gorm.Scope, fromNames []string, to *gorm.Scope, toNames []string) {
+	for i, name := range toNames {
+		if i >= len(fromNames) {
+			return
+		}
+
+		source, ok := from.FieldByName(fromNames[i])
+
+		if !ok || source.IsBlank {
+			continue
+		}
+
+		if target, ok := to.FieldByName(name); ok {
+			target.Set(source.Field.Interface())
+		}
+	}
+}
+
+// FindField looks a field up by column name, or case-insensitively by name
+func FindField(scope *gorm.Scope, column string) (*gorm.Field, bool) {
+	for _, field := range scope.Fields() {
+		if field.DBName == column || strings.EqualFold(field.Name, column) {
+			return field, true
+		}
+	}
+
+	return nil, false
+}
+
+// IndirectType returns the struct type behind pointers and slices
+func IndirectType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+
+	return typ
+}
+
+// addr returns a pointer to elem, which is either a pointer already or an
+// element of a slice under construction
+func addr(elem reflect.Value) reflect.Value {
+	if elem.Kind() == reflect.Ptr {
+		return elem
+	}
+
+	if elem.CanAddr() {
+		return elem.Addr()
+	}
+
+	ptr := reflect.New(elem.Type())
+	ptr.Elem().Set(elem)
+
+	return ptr
+}
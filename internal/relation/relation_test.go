@@ -0,0 +1,82 @@
+package relation_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iantanwx/gorm-expect/internal/relation"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type User struct {
+	Id          int64
+	Emails      []Email
+	Attachments []Attachment `gorm:"polymorphic:Owner;"`
+}
+
+type Email struct {
+	Id     int64
+	UserId int64
+}
+
+type Attachment struct {
+	Id        int64
+	OwnerId   int64
+	OwnerType string
+}
+
+type Order struct {
+	Id     int64
+	UserId int64
+	User   User
+}
+
+var db *gorm.DB
+
+func init() {
+	sqlDB, _, err := sqlmock.New()
+
+	if err != nil {
+		panic(err)
+	}
+
+	if db, err = gorm.Open("common", sqlDB); err != nil {
+		panic(err)
+	}
+}
+
+func setForeignKeys(value interface{}, name string, elem reflect.Value) {
+	scope := db.NewScope(value)
+	field, _ := relation.FindField(scope, name)
+	relation.SetForeignKeys(scope, field, elem)
+}
+
+func TestSetForeignKeys(t *testing.T) {
+	user := User{Id: 1}
+	emails := []Email{{Id: 1}, {Id: 2, UserId: 2}}
+
+	for i := range emails {
+		setForeignKeys(&user, "emails", reflect.ValueOf(emails).Index(i))
+	}
+
+	assert.Equal(t, []Email{{Id: 1, UserId: 1}, {Id: 2, UserId: 1}}, emails)
+
+	attachment := Attachment{Id: 1, OwnerType: "emails"}
+	setForeignKeys(&user, "Attachments", reflect.ValueOf(&attachment))
+
+	assert.Equal(t, Attachment{Id: 1, OwnerId: 1, OwnerType: "users"}, attachment)
+
+	order := Order{Id: 1, UserId: 2}
+	setForeignKeys(&order, "User", reflect.ValueOf(&User{Id: 3}))
+
+	assert.Equal(t, int64(3), order.UserId)
+}
+
+func TestSetForeignKeysBlankKey(t *testing.T) {
+	email := Email{Id: 1, UserId: 2}
+	setForeignKeys(&User{}, "emails", reflect.ValueOf(&email))
+
+	assert.Equal(t, int64(2), email.UserId)
+}
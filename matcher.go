@@ -0,0 +1,202 @@
+package gormexpect
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// QueryMatcher decides which statements satisfy an expectation. go-sqlmock
+// only matches statements against regular expressions, so a QueryMatcher
+// converts the expected SQL to one.
+type QueryMatcher interface {
+	Pattern(sql string) (string, error)
+}
+
+// QueryMatcherFunc adapts a function to a QueryMatcher
+type QueryMatcherFunc func(sql string) (string, error)
+
+// Pattern calls f(sql)
+func (f QueryMatcherFunc) Pattern(sql string) (string, error) {
+	return f(sql)
+}
+
+var (
+	// QueryMatcherExact matches the statement exactly as it was expected
+	QueryMatcherExact QueryMatcher = QueryMatcherFunc(exactPattern)
+
+	// QueryMatcherRegexp uses the SQL given to Query and ExecSQL as a regular
+	// expression. SQL that gorm generates for the other expectations is not
+	// a regular expression, so it is still matched exactly.
+	QueryMatcherRegexp QueryMatcher = regexpMatcher{}
+
+	// QueryMatcherNormalized matches statements with the same tokens,
+	// ignoring whitespace and the case of keywords and identifiers
+	QueryMatcherNormalized QueryMatcher = QueryMatcherFunc(normalizedPattern)
+
+	// QueryMatcherAST compares the parse trees of statements, so that the
+	// order of selected, inserted and updated columns and parentheses that
+	// don't change the meaning of a condition don't matter. It only works
+	// with the adapter of NewDefaultExpecter, which parses the executed
+	// statements when go-sqlmock reports that they don't match.
+	QueryMatcherAST QueryMatcher = QueryMatcherFunc(astPattern)
+)
+
+// MatchWith returns an Expecter whose expectations are matched with m. Use it
+// for a single expectation, or assign the result to use it for every one:
+//
+//	expect.MatchWith(gormexpect.QueryMatcherAST).Find(&users).Returns(users)
+//	expect = expect.MatchWith(gormexpect.QueryMatcherNormalized)
+//
+// By default, statements are matched exactly, except for inserts and
+// updates, whose columns may be in any order.
+func (h *Expecter) MatchWith(m QueryMatcher) *Expecter {
+	clone := h.clone()
+	clone.gorm = clone.gorm.Set("gorm_expect:matcher", m)
+
+	return clone
+}
+
+// matcher returns the QueryMatcher set with MatchWith, if any
+func (h *Expecter) matcher() QueryMatcher {
+	if h.gorm == nil {
+		return nil
+	}
+
+	if m, ok := h.gorm.Get("gorm_expect:matcher"); ok {
+		return m.(QueryMatcher)
+	}
+
+	return nil
+}
+
+func exactPattern(sql string) (string, error) {
+	return "^" + regexp.QuoteMeta(strings.TrimSpace(sql)) + "$", nil
+}
+
+// regexpMatcher is QueryMatcherRegexp. It has a type of its own so that
+// recordWithMatcher can tell it apart.
+type regexpMatcher struct{}
+
+// Pattern implements QueryMatcher. sql must compile as a regular expression.
+func (regexpMatcher) Pattern(sql string) (string, error) {
+	if _, err := regexp.Compile(sql); err != nil {
+		return "", err
+	}
+
+	return sql, nil
+}
+
+func normalizedPattern(sql string) (string, error) {
+	tokens, err := tokenize(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	var pattern strings.Builder
+
+	pattern.WriteString(`^\s*`)
+
+	for i, tok := range tokens {
+		if i > 0 {
+			// words have to stay apart
+			if isWordToken(tokens[i-1]) && isWordToken(tok) {
+				pattern.WriteString(`\s+`)
+			} else {
+				pattern.WriteString(`\s*`)
+			}
+		}
+
+		text := regexp.QuoteMeta(sql[tok.pos:tok.end])
+
+		// literals are compared exactly
+		if tok.kind == tokenWord || tok.kind == tokenIdent {
+			text = "(?i:" + text + ")"
+		}
+
+		pattern.WriteString(text)
+	}
+
+	pattern.WriteString(`\s*$`)
+
+	return pattern.String(), nil
+}
+
+func isWordToken(tok token) bool {
+	return tok.kind == tokenWord || tok.kind == tokenNumber || tok.kind == tokenPlaceholder
+}
+
+func astPattern(sql string) (string, error) {
+	tree, err := parseTree(sql)
+
+	if err != nil {
+		return "", err
+	}
+
+	return "^" + regexp.QuoteMeta(treeMarker+tree.String()) + "$", nil
+}
+
+// closingParen returns the index of the parenthesis closing the one at i
+func closingParen(tokens []token, i int) int {
+	depth := 0
+
+	for j := i; j < len(tokens); j++ {
+		depth += parenDepth(tokens[j])
+
+		if depth == 0 {
+			return j
+		}
+	}
+
+	return len(tokens) - 1
+}
+
+// parenDepth returns how much tok changes the nesting of parentheses and
+// brackets, so that commas inside them don't separate list items
+func parenDepth(tok token) int {
+	if tok.is("(") || tok.is("[") {
+		return 1
+	}
+
+	if tok.is(")") || tok.is("]") {
+		return -1
+	}
+
+	return 0
+}
+
+// recordWithMatcher records stmt, using the QueryMatcher set with MatchWith
+// to match it. false is returned if no matcher was set.
+func recordWithMatcher(scope *gorm.Scope, recorder *Recorder, stmt Stmt) bool {
+	value, ok := scope.Get("gorm_expect:matcher")
+
+	if !ok || value == nil {
+		return false
+	}
+
+	m := value.(QueryMatcher)
+
+	// the statement was generated by gorm, so it isn't a regular expression
+	if _, ok := m.(regexpMatcher); ok {
+		m = QueryMatcherExact
+	}
+
+	pattern, err := m.Pattern(stmt.sql)
+
+	if err != nil {
+		scope.Err(fmt.Errorf("Cannot match %s: %s", stmt.sql, err))
+		pattern = regexp.QuoteMeta(stmt.sql)
+	}
+
+	if stmt.raw == "" {
+		stmt.raw = stmt.sql
+	}
+
+	stmt.sql = pattern
+	recorder.Record(stmt, false)
+
+	return true
+}
@@ -0,0 +1,211 @@
+package gormexpect_test
+
+import (
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchWithExact(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []User{}
+	out := []User{User{Id: 1, Name: "jinzhu"}}
+
+	expect.MatchWith(expecter.QueryMatcherExact).Find(&in).Returns(out)
+	db.Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+
+	// the default pattern for raw SQL would match a longer statement
+	expect.MatchWith(expecter.QueryMatcherExact).Query("SELECT * FROM users").Returns(out)
+	err = db.Raw("SELECT * FROM users WHERE id = 1").Scan(&in).Error
+
+	assert.NotNil(t, err)
+
+	db.Raw("SELECT * FROM users").Scan(&in)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestMatchWithRegexp(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = expect.MatchWith(expecter.QueryMatcherRegexp)
+
+	in := User{}
+	out := User{Id: 1, Name: "jinzhu"}
+
+	expect.Query(`^SELECT .* FROM users WHERE id = \d+$`).Returns(out)
+	db.Raw("SELECT * FROM users WHERE id = 1").Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+
+	// SQL generated by gorm is still matched exactly
+	users := []User{}
+
+	expect.Find(&users).Returns([]User{out})
+	db.Find(&users)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, []User{out}, users)
+
+	assert.PanicsWithError(t, "Cannot match SELECT (: error parsing regexp: missing closing ): `SELECT (`", func() {
+		expect.Query("SELECT (")
+	})
+}
+
+func TestMatchWithNormalized(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{}
+	out := User{Id: 1, Name: "jinzhu"}
+
+	expect.MatchWith(expecter.QueryMatcherNormalized).Query("select * from users where name=?", "jinzhu").Returns(out)
+	db.Raw("SELECT *\n\tFROM users\n\tWHERE name = ?", "jinzhu").Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
+
+func TestMatchWithNormalizedLiterals(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{}
+
+	// string literals keep their case
+	expect.MatchWith(expecter.QueryMatcherNormalized).Query("select * from users where name = 'jinzhu'").Returns(User{})
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE name = 'JINZHU'").Scan(&in).Error)
+
+	db.Raw("SELECT * FROM USERS WHERE NAME = 'jinzhu'").Scan(&in)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestMatchWithAST(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = expect.MatchWith(expecter.QueryMatcherAST)
+
+	in := User{}
+	out := User{Id: 1, Name: "jinzhu"}
+
+	expect.Query("SELECT name, id FROM users WHERE ((id = ?) AND (name = ?))").Returns(out)
+	db.Raw("SELECT id, name FROM users WHERE id = ? AND name = ?", 1, "jinzhu").Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+
+	expect.ExecSQL(`INSERT INTO "users" ("age","name") VALUES (?,?)`).WillSucceed(1, 1)
+	result := db.Exec(`INSERT INTO "users" ("name", "age") VALUES (?, ?)`, "jinzhu", 20)
+
+	assert.Nil(t, result.Error)
+	assert.Nil(t, expect.AssertExpectations())
+
+	// a different column is still a different statement
+	expect.ExecSQL(`INSERT INTO "users" ("age","name") VALUES (?,?)`).WillSucceed(1, 1)
+	result = db.Exec(`INSERT INTO "users" ("name", "email") VALUES (?, ?)`, "jinzhu", "jinzhu@example.org")
+
+	assert.NotNil(t, result.Error)
+
+	db.Exec(`INSERT INTO "users" ("age", "name") VALUES (?, ?)`, 20, "jinzhu")
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestMatchWithASTPrecedence(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = expect.MatchWith(expecter.QueryMatcherAST)
+	in := []User{}
+
+	// parentheses that change the meaning of a condition have to match
+	expect.Query("SELECT * FROM users WHERE (age = 1 OR age = 2) AND name = 'jinzhu'").Returns([]User{})
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE age = 1 OR (age = 2 AND name = 'jinzhu')").Scan(&in).Error)
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE age = 1 OR age = 2 AND name = 'jinzhu'").Scan(&in).Error)
+
+	db.Raw("SELECT * FROM users WHERE ((age = 1) OR (age = 2)) AND (name = 'jinzhu')").Scan(&in)
+	assert.Nil(t, expect.AssertExpectations())
+
+	expect.Query("SELECT * FROM users WHERE NOT (age = 1 AND name = 'jinzhu')").Returns([]User{})
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE NOT age = 1 AND name = 'jinzhu'").Scan(&in).Error)
+
+	db.Raw("SELECT * FROM users WHERE NOT ((age = 1) AND name = 'jinzhu')").Scan(&in)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestMatchWithASTRepeatedColumn(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = expect.MatchWith(expecter.QueryMatcherAST)
+	in := []User{}
+
+	expect.Query("SELECT id, name FROM users").Returns([]User{})
+	assert.NotNil(t, db.Raw("SELECT id, id FROM users").Scan(&in).Error)
+	assert.NotNil(t, db.Raw("SELECT name, id, id FROM users").Scan(&in).Error)
+
+	db.Raw("SELECT name, id FROM users").Scan(&in)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestMatchWithASTUnbalanced(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = expect.MatchWith(expecter.QueryMatcherAST)
+	in := []User{}
+
+	assert.PanicsWithError(t, "Cannot match SELECT * FROM users WHERE (id = ?: Unclosed ( at 26 in SELECT * FROM users WHERE (id = ?", func() {
+		expect.Query("SELECT * FROM users WHERE (id = ?")
+	})
+
+	assert.PanicsWithError(t, "Cannot match SELECT * FROM users WHERE id = ?): Unbalanced ) at 32 in SELECT * FROM users WHERE id = ?)", func() {
+		expect.Query("SELECT * FROM users WHERE id = ?)")
+	})
+
+	expect.Query("SELECT * FROM users WHERE (id = ?)", 1).Returns([]User{})
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE ((id = ?)", 1).Scan(&in).Error)
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE (id = ?))", 1).Scan(&in).Error)
+
+	db.Raw("SELECT * FROM users WHERE id = ?", 1).Scan(&in)
+	assert.Nil(t, expect.AssertExpectations())
+}
@@ -1,10 +1,12 @@
 package gormexpect
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
 	"io"
+	"regexp"
 	"sync"
 
 	"github.com/jinzhu/gorm"
@@ -60,12 +62,20 @@ func (r NoopResult) RowsAffected() (int64, error) {
 
 // NoopRows implements driver.Rows
 type NoopRows struct {
-	pos int
+	pos     int
+	columns []string
+	rows    [][]driver.Value
+}
+
+// NewNoopRows returns NoopRows with the given columns and rows. Each row
+// should have a value for every column.
+func NewNoopRows(columns []string, rows [][]driver.Value) *NoopRows {
+	return &NoopRows{columns: columns, rows: rows}
 }
 
 // Columns implements driver.Rows
 func (r *NoopRows) Columns() []string {
-	return []string{"foo", "bar", "baz", "lol", "kek", "zzz"}
+	return r.columns
 }
 
 // Close implements driver.Rows
@@ -73,24 +83,23 @@ func (r *NoopRows) Close() error {
 	return nil
 }
 
-// Next implements driver.Rows and alwys returns only one row
+// Next implements driver.Rows
 func (r *NoopRows) Next(dest []driver.Value) error {
-	if r.pos == 1 {
+	if r.pos >= len(r.rows) {
 		return io.EOF
 	}
-	cols := []string{"foo", "bar", "baz", "lol", "kek", "zzz"}
-
-	for i, col := range cols {
-		dest[i] = col
-	}
 
+	copy(dest, r.rows[r.pos])
 	r.pos++
 
 	return nil
 }
 
 // NoopStmt implements driver.Stmt
-type NoopStmt struct{}
+type NoopStmt struct {
+	conn  *NoopConnection
+	query string
+}
 
 // Close implements driver.Stmt
 func (s *NoopStmt) Close() error {
@@ -99,17 +108,84 @@ func (s *NoopStmt) Close() error {
 
 // NumInput implements driver.Stmt
 func (s *NoopStmt) NumInput() int {
-	return 1
+	return countPlaceholders(s.query)
+}
+
+// countPlaceholders counts the ? and $n placeholders in query, ignoring those
+// in quoted strings and identifiers
+func countPlaceholders(query string) int {
+	var (
+		quote     rune
+		count     int
+		maxNumber int
+	)
+
+	for i, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '?':
+			count++
+		case r == '$':
+			number := 0
+
+			for _, d := range query[i+1:] {
+				if d < '0' || d > '9' {
+					break
+				}
+
+				number = number*10 + int(d-'0')
+			}
+
+			if number > maxNumber {
+				maxNumber = number
+			}
+		}
+	}
+
+	if maxNumber > count {
+		return maxNumber
+	}
+
+	return count
 }
 
 // Exec implements driver.Stmt
 func (s *NoopStmt) Exec(args []driver.Value) (driver.Result, error) {
-	return &NoopResult{}, nil
+	return s.conn.Exec(s.query, args)
 }
 
 // Query implements driver.Stmt
 func (s *NoopStmt) Query(args []driver.Value) (driver.Rows, error) {
-	return &NoopRows{}, nil
+	return s.conn.Query(s.query, args)
+}
+
+// NoopStep is a scripted result for the noop database. It applies to the
+// first statement of the given Kind ("query" or "exec", or either if empty)
+// whose SQL matches Pattern (or any SQL if nil). Queries return Columns and
+// Rows, or no rows at all if Empty is set. Execs return LastInsertID and
+// RowsAffected. If Err is set, it is returned instead.
+type NoopStep struct {
+	Kind         string
+	Pattern      *regexp.Regexp
+	Columns      []string
+	Rows         [][]driver.Value
+	Empty        bool
+	LastInsertID int64
+	RowsAffected int64
+	Err          error
+}
+
+func (s NoopStep) matches(kind, query string) bool {
+	if s.Kind != "" && s.Kind != kind {
+		return false
+	}
+
+	return s.Pattern == nil || s.Pattern.MatchString(query)
 }
 
 // NewNoopDB initialises a new DefaultNoopDB
@@ -118,7 +194,7 @@ func NewNoopDB() (gorm.SQLCommon, NoopController, error) {
 	dsn := fmt.Sprintf("noop_db_%d", pool.counter)
 	pool.counter++
 
-	noop := &NoopConnection{nextExecResult: []int64{0, 0}, dsn: dsn, drv: pool}
+	noop := &NoopConnection{dsn: dsn, drv: pool}
 	pool.conns[dsn] = noop
 	pool.Unlock()
 
@@ -132,11 +208,14 @@ func NewNoopDB() (gorm.SQLCommon, NoopController, error) {
 // require it for generating queries. It is necessary because eager loading
 // will fail if any operation returns an error
 type NoopConnection struct {
-	dsn            string
-	drv            *NoopDriver
-	opened         int
-	returnNilRows  bool
-	nextExecResult []int64
+	// mu guards the state set by NoopController, which may be used from
+	// other goroutines than the ones using the connection
+	mu       sync.Mutex
+	dsn      string
+	drv      *NoopDriver
+	opened   int
+	script   []NoopStep
+	nextRows *NoopRows
 }
 
 func (c *NoopConnection) open() (*sql.DB, error) {
@@ -165,7 +244,9 @@ func (c *NoopConnection) Close() error {
 // NoopController provides a crude interface for manipulating NoopConnection
 type NoopController interface {
 	ReturnNilRows()
+	ReturnRows(columns []string, rows [][]driver.Value)
 	ReturnExecResult(lastReturnedID, rowsAffected int64)
+	Script(steps ...NoopStep)
 }
 
 // Begin implements sql/driver.Conn
@@ -173,40 +254,153 @@ func (c *NoopConnection) Begin() (driver.Tx, error) {
 	return c, nil
 }
 
-// Exec implements sql/driver.Conn
+// BeginTx implements sql/driver.ConnBeginTx
+func (c *NoopConnection) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.Begin()
+}
+
+// ExecContext implements sql/driver.ExecerContext
+func (c *NoopConnection) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.Exec(query, namedValuesToValues(args))
+}
+
+// QueryContext implements sql/driver.QueryerContext
+func (c *NoopConnection) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.Query(query, namedValuesToValues(args))
+}
+
+// CheckNamedValue implements sql/driver.NamedValueChecker. The noop driver
+// never looks at args, so any value is accepted as is. This includes values
+// that database/sql would otherwise reject, such as sqlmock.AnyArg().
+func (c *NoopConnection) CheckNamedValue(value *driver.NamedValue) error {
+	return nil
+}
+
+// ResetSession implements sql/driver.SessionResetter. The script is shared by
+// every connection to the noop database, so there is nothing to reset.
+func (c *NoopConnection) ResetSession(ctx context.Context) error {
+	return nil
+}
+
+func namedValuesToValues(named []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(named))
+
+	for i, value := range named {
+		values[i] = value.Value
+	}
+
+	return values
+}
+
+// Exec implements sql/driver.Conn. It returns the result of the next matching
+// step in the script, or 0, 0 if there is none.
 func (c *NoopConnection) Exec(query string, args []driver.Value) (driver.Result, error) {
-	defer func() {
-		c.nextExecResult = []int64{0, 0}
-	}()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return NoopResult{c.nextExecResult[0], c.nextExecResult[1]}, nil
+	step, ok := c.nextStep("exec", query)
+
+	if !ok {
+		return NoopResult{}, nil
+	}
+
+	if step.Err != nil {
+		return nil, step.Err
+	}
+
+	return NoopResult{step.LastInsertID, step.RowsAffected}, nil
 }
 
 // Prepare implements sql/driver.Conn
 func (c *NoopConnection) Prepare(query string) (driver.Stmt, error) {
-	return &NoopStmt{}, nil
+	return &NoopStmt{conn: c, query: query}, nil
 }
 
-// Query implements sql/driver.Conn
+// Query implements sql/driver.Conn. It returns the rows of the next matching
+// step in the script. Otherwise, it returns the rows passed to ReturnRows, or
+// a single row without any columns if there are none.
 func (c *NoopConnection) Query(query string, args []driver.Value) (driver.Rows, error) {
-	if c.returnNilRows {
-		c.returnNilRows = false
-		return &NoopRows{pos: 1}, nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rows := c.nextRows
+	c.nextRows = nil
+
+	if rows == nil {
+		rows = NewNoopRows([]string{}, [][]driver.Value{[]driver.Value{}})
 	}
 
-	return &NoopRows{}, nil
+	step, ok := c.nextStep("query", query)
+
+	if !ok {
+		return rows, nil
+	}
+
+	if step.Err != nil {
+		return nil, step.Err
+	}
+
+	if step.Empty {
+		return NewNoopRows(rows.columns, nil), nil
+	}
+
+	return NewNoopRows(step.Columns, step.Rows), nil
+}
+
+// nextStep removes and returns the first step in the script that matches the
+// statement. Callers must hold c.mu.
+func (c *NoopConnection) nextStep(kind, query string) (NoopStep, bool) {
+	for i, step := range c.script {
+		if step.matches(kind, query) {
+			c.script = append(c.script[:i:i], c.script[i+1:]...)
+			return step, true
+		}
+	}
+
+	return NoopStep{}, false
 }
 
-// ReturnNilRows instructs the noop driver to return empty rows for all queries
-// until returnNilRows is set to false
+// ReturnNilRows instructs the noop driver to return empty rows for the next
+// call to Query
 func (c *NoopConnection) ReturnNilRows() {
-	c.returnNilRows = true
+	c.Script(NoopStep{Kind: "query", Empty: true})
+}
+
+// ReturnRows will cause the driver to return the passed rows for the next call
+// to Query, unless the script has a step for it
+func (c *NoopConnection) ReturnRows(columns []string, rows [][]driver.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextRows = NewNoopRows(columns, rows)
 }
 
 // ReturnExecResult will cause the driver to return the passed values for the
 // next call to Exec. It goes back to the default of 0, 0 thereafter.
 func (c *NoopConnection) ReturnExecResult(lastReturnedID, rowsAffected int64) {
-	c.nextExecResult = []int64{lastReturnedID, rowsAffected}
+	c.Script(NoopStep{Kind: "exec", LastInsertID: lastReturnedID, RowsAffected: rowsAffected})
+}
+
+// Script appends steps to the script of results returned by the driver. Each
+// step is used once, by the first statement it matches, so steps for the same
+// kind of statement are used in order.
+func (c *NoopConnection) Script(steps ...NoopStep) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.script = append(c.script, steps...)
 }
 
 // Commit implements sql/driver.Conn
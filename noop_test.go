@@ -0,0 +1,237 @@
+package gormexpect_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+)
+
+type Status int
+
+func (s *Status) Scan(value interface{}) error {
+	str, ok := value.([]byte)
+
+	if !ok {
+		return fmt.Errorf("Cannot scan %T into Status", value)
+	}
+
+	switch string(str) {
+	case "active":
+		*s = 1
+	default:
+		*s = 0
+	}
+
+	return nil
+}
+
+func (s Status) Value() (driver.Value, error) {
+	if s == 1 {
+		return []byte("active"), nil
+	}
+
+	return []byte("inactive"), nil
+}
+
+type Account struct {
+	Id       int64
+	Status   Status
+	Profiles []Profile
+}
+
+type Profile struct {
+	Id        int64
+	AccountId int64
+	Score     float64
+}
+
+func TestNoopReturnRows(t *testing.T) {
+	noop, noopc, err := expecter.NewNoopDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noopc.ReturnRows([]string{"id", "score"}, [][]driver.Value{
+		[]driver.Value{int64(1), 1.5},
+		[]driver.Value{int64(2), 2.5},
+	})
+
+	rows, err := noop.Query("SELECT id, score FROM profiles")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	var profiles []Profile
+
+	for rows.Next() {
+		var profile Profile
+		assert.Nil(t, rows.Scan(&profile.Id, &profile.Score))
+		profiles = append(profiles, profile)
+	}
+
+	assert.Equal(t, []Profile{Profile{Id: 1, Score: 1.5}, Profile{Id: 2, Score: 2.5}}, profiles)
+}
+
+func TestNoopRowsCustomScanner(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := Account{Id: 1}
+	out := Account{
+		Id:     1,
+		Status: 1,
+		Profiles: []Profile{
+			Profile{Id: 1, AccountId: 1, Score: 1.5},
+			Profile{Id: 2, AccountId: 1, Score: 2.5},
+		},
+	}
+
+	expect.Preload("Profiles").Find(&in).Returns(out)
+	err = db.Preload("Profiles").Find(&in).Error
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Nil(t, err)
+	assert.Equal(t, out, in)
+}
+
+func TestNoopConcurrentControl(t *testing.T) {
+	noop, noopc, err := expecter.NewNoopDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			noopc.ReturnExecResult(int64(i), 1)
+			noopc.ReturnRows([]string{"id"}, [][]driver.Value{[]driver.Value{int64(i)}})
+			noopc.ReturnNilRows()
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := noop.Exec("UPDATE profiles SET score = 0"); err != nil {
+				t.Error(err)
+			}
+
+			rows, err := noop.Query("SELECT id FROM profiles")
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			rows.Close()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestNoopScript(t *testing.T) {
+	noop, noopc, err := expecter.NewNoopDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noopc.Script(
+		expecter.NoopStep{Kind: "query", Pattern: regexp.MustCompile("FROM accounts"), Empty: true},
+		expecter.NoopStep{Kind: "exec", RowsAffected: 2},
+		expecter.NoopStep{Kind: "query", Columns: []string{"id"}, Rows: [][]driver.Value{[]driver.Value{int64(7)}}},
+		expecter.NoopStep{Kind: "exec", Err: errors.New("boom")},
+	)
+
+	// the first query does not match the pattern, so it gets the next query step
+	var id int64
+	assert.Nil(t, noop.QueryRow("SELECT id FROM profiles").Scan(&id))
+	assert.Equal(t, int64(7), id)
+
+	err = noop.QueryRow("SELECT id FROM accounts").Scan(&id)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	result, err := noop.Exec("UPDATE accounts SET status = 1")
+	assert.Nil(t, err)
+	affected, _ := result.RowsAffected()
+	assert.Equal(t, int64(2), affected)
+
+	_, err = noop.Exec("UPDATE accounts SET status = 0")
+	assert.EqualError(t, err, "boom")
+
+	// the script is exhausted
+	result, err = noop.Exec("UPDATE accounts SET status = 0")
+	assert.Nil(t, err)
+	affected, _ = result.RowsAffected()
+	assert.Equal(t, int64(0), affected)
+}
+
+func TestNoopContext(t *testing.T) {
+	noop, _, err := expecter.NewNoopDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := noop.(*sql.DB)
+
+	// values the default converter rejects are accepted
+	_, err = db.ExecContext(context.Background(), "UPDATE accounts SET tags = ? WHERE name = ?", []string{"a", "b"}, sql.NullString{})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = db.QueryContext(ctx, "SELECT id FROM accounts")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestNoopNumInput(t *testing.T) {
+	noop, _, err := expecter.NewNoopDB()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := noop.Prepare("SELECT id FROM accounts WHERE status = ? AND name = '?'")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stmt.Exec(1)
+	assert.Nil(t, err)
+
+	_, err = stmt.Exec(1, 2)
+	assert.NotNil(t, err)
+
+	stmt, err = noop.Prepare("SELECT id FROM accounts WHERE status = $1 OR status = $2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stmt.Exec(1, 2)
+	assert.Nil(t, err)
+}
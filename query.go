@@ -1,9 +1,12 @@
 package gormexpect
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/jinzhu/gorm"
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -26,7 +29,9 @@ type SqlmockQueryExpectation struct {
 
 // Returns accepts an out type which should either be a struct or slice. Under
 // the hood, it converts a gorm model struct to sql.Rows that can be passed to
-// the underlying mock db
+// the underlying mock db. When preloading onto a slice, the associations of
+// every element are returned by a single preload query, with blank foreign
+// keys filled in so that gorm can assign them to the right parent.
 func (q *SqlmockQueryExpectation) Returns(out interface{}) *Expecter {
 	scope := (&gorm.Scope{}).New(out)
 	q.scope = scope
@@ -41,19 +46,38 @@ func (q *SqlmockQueryExpectation) Returns(out interface{}) *Expecter {
 	outVal := indirect(reflect.ValueOf(out))
 
 	destQuery := q.parent.recorder.stmts[0]
+	destRows, err := q.getDestRows(out)
 
-	// main query always at the head of the slice
-	q.parent.adapter.ExpectQuery(destQuery).Returns(q.getDestRows(out))
+	// main query always at the head of the slice. Rows that can't be built
+	// make the query fail, and are reported by AssertExpectations.
+	if err != nil {
+		addExpectError(q.parent.gorm, err)
+		q.parent.adapter.ExpectQuery(destQuery).Errors(err)
+		q.parent.reset()
+
+		return q.parent
+	}
+
+	q.parent.adapter.ExpectQuery(destQuery).Returns(destRows)
 
 	if len(q.parent.recorder.stmts) > 1 {
 		// subqueries are preload
 		for _, subQuery := range q.parent.recorder.stmts[1:] {
 			if subQuery.preload != "" {
 				fmt.Printf("Preloading: %s\r\n", subQuery.preload)
-				if field, ok := scope.FieldByName(subQuery.preload); ok {
+				if field, parents, values := getPreloadValues(outVal, subQuery.preload); field != nil {
 					expectation := q.parent.adapter.ExpectQuery(subQuery)
-					rows := q.getRelationRows(outVal.FieldByName(subQuery.preload), subQuery.preload, field.Relationship)
-					expectation.Returns(rows)
+					expectation.Args(getPreloadArgs(subQuery, field, parents)...)
+					columns, rows, err := getRelationValues(field, values)
+
+					// gorm stops preloading at the first error
+					if err != nil {
+						addExpectError(q.parent.gorm, err)
+						expectation.Errors(err)
+						break
+					}
+
+					expectation.Returns(toSqlmockRows(columns, rows))
 				}
 			}
 		}
@@ -64,131 +88,372 @@ func (q *SqlmockQueryExpectation) Returns(out interface{}) *Expecter {
 	return q.parent
 }
 
-func (q *SqlmockQueryExpectation) getRelationRows(rVal reflect.Value, fieldName string, relation *gorm.Relationship) *sqlmock.Rows {
+// preloadValue is a value that should be returned by a preload query, along
+// with the parent it belongs to
+type preloadValue struct {
+	parent reflect.Value
+	value  reflect.Value
+}
+
+// getPreloadValues walks a preload schema from out and returns the
+// relationship field at the end of it, the parents it is loaded for, and every
+// value its preload query should return. Nested schemas (e.g. "Orders.Items")
+// are resolved one level at a time, with the values of each level becoming the
+// parents of the next.
+func getPreloadValues(out reflect.Value, schema string) (*gorm.StructField, []reflect.Value, []preloadValue) {
 	var (
-		rows    *sqlmock.Rows
-		columns []string
+		field   *gorm.StructField
+		values  []preloadValue
+		parents []reflect.Value
 	)
 
-	switch relation.Kind {
-	case "has_one":
-		scope := &gorm.Scope{Value: rVal.Interface()}
+	out = indirect(out)
 
-		for _, field := range scope.GetModelStruct().StructFields {
-			if field.IsNormal {
-				columns = append(columns, field.DBName)
+	if !out.IsValid() {
+		return nil, nil, nil
+	}
+
+	// associations of a slice of parents are loaded by a single query
+	isSlice := out.Kind() == reflect.Slice
+	modelType := getElemType(out.Type())
+
+	if isSlice {
+		for i := 0; i < out.Len(); i++ {
+			if parent := indirect(out.Index(i)); parent.IsValid() {
+				parents = append(parents, parent)
 			}
 		}
+	} else {
+		parents = append(parents, out)
+	}
+
+	for i, fieldName := range strings.Split(schema, ".") {
+		var ok bool
 
-		rows = sqlmock.NewRows(columns)
+		// the values of the previous level are the parents of this one, and
+		// gorm preloads onto a slice if the previous level was a slice
+		if i > 0 {
+			parents = []reflect.Value{}
+			for _, value := range values {
+				parents = append(parents, value.value)
+			}
 
-		if reflect.DeepEqual(rVal.Interface(), reflect.New(rVal.Type()).Elem().Interface()) {
-			return rows
+			isSlice = isSlice || field.Struct.Type.Kind() == reflect.Slice
+			modelType = getElemType(field.Struct.Type)
 		}
 
-		// we don't have a slice
-		row := getRowForFields(scope.Fields())
-		rows = rows.AddRow(row...)
+		if field, ok = getPreloadField(modelType, fieldName); !ok {
+			return nil, nil, nil
+		}
 
-		return rows
-	case "has_many":
-		elem := rVal.Type().Elem()
-		scope := &gorm.Scope{Value: reflect.New(elem).Interface()}
+		values = []preloadValue{}
+		loaded := make(map[string]bool)
 
-		for _, field := range scope.GetModelStruct().StructFields {
-			if field.IsNormal {
-				columns = append(columns, field.DBName)
+		for _, parent := range parents {
+			fieldVal := parent.FieldByName(fieldName)
+
+			if fieldVal.Kind() == reflect.Slice {
+				for i := 0; i < fieldVal.Len(); i++ {
+					if elem := indirect(fieldVal.Index(i)); elem.IsValid() {
+						values = append(values, preloadValue{parent, getPreloadValue(parent, elem, field.Relationship, isSlice)})
+					}
+				}
+
+				continue
 			}
+
+			elem := indirect(fieldVal)
+
+			if !elem.IsValid() || isZero(elem) {
+				continue
+			}
+
+			value := getPreloadValue(parent, elem, field.Relationship, isSlice)
+
+			// parents may share a belongs_to association, but the database
+			// only returns it once
+			if field.Relationship.Kind == "belongs_to" {
+				key := fmt.Sprint((&gorm.Scope{Value: value.Interface()}).PrimaryKeyValue())
+
+				if loaded[key] {
+					continue
+				}
+
+				loaded[key] = true
+			}
+
+			values = append(values, preloadValue{parent, value})
 		}
 
-		rows = sqlmock.NewRows(columns)
+	}
+
+	return field, parents, values
+}
 
-		if reflect.DeepEqual(rVal.Interface(), reflect.New(rVal.Type()).Elem().Interface()) {
-			return rows
+// getPreloadArgs returns the args a preload query should be executed with.
+// gorm collects the keys of parents in a map, so the order of keys differs
+// between runs and each of them is matched against all the keys. Any other
+// args, e.g. from preload conditions, must match exactly.
+func getPreloadArgs(stmt Stmt, field *gorm.StructField, parents []reflect.Value) []driver.Value {
+	var (
+		args     []driver.Value
+		keys     anyOf
+		relation = field.Relationship
+		names    = relation.ForeignFieldNames
+	)
+
+	if relation.Kind == "has_one" || relation.Kind == "has_many" {
+		names = relation.AssociationForeignFieldNames
+	}
+
+	for _, parent := range parents {
+		parentScope := &gorm.Scope{Value: parent.Interface()}
+
+		for _, name := range names {
+			if keyField, ok := parentScope.FieldByName(name); ok && !keyField.IsBlank {
+				if key, err := driver.DefaultParameterConverter.ConvertValue(keyField.Field.Interface()); err == nil {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+
+	start, end := keyPlaceholders(stmt.raw)
+
+	for i, arg := range stmt.args {
+		if i >= start && i < end {
+			args = append(args, keys)
+			continue
+		}
+
+		args = append(args, arg)
+	}
+
+	return args
+}
+
+// keyPlaceholders returns the range of placeholders in the IN (...) list
+// that gorm filters a preload query on. gorm adds it before any preload
+// conditions, so it is the first IN list of the statement.
+func keyPlaceholders(sql string) (int, int) {
+	tokens, err := tokenize(sql)
+
+	if err != nil {
+		return 0, 0
+	}
+
+	placeholders := 0
+
+	for i, tok := range tokens {
+		if tok.kind == tokenPlaceholder {
+			placeholders++
+			continue
 		}
 
-		if rVal.Len() > 0 {
-			for i := 0; i < rVal.Len(); i++ {
-				scope := &gorm.Scope{Value: rVal.Index(i).Interface()}
-				row := getRowForFields(scope.Fields())
-				rows = rows.AddRow(row...)
+		if !tok.is("IN") || i+1 >= len(tokens) || !tokens[i+1].is("(") {
+			continue
+		}
+
+		start := placeholders
+
+		for _, inner := range tokens[i+1 : closingParen(tokens, i+1)] {
+			if inner.kind == tokenPlaceholder {
+				placeholders++
 			}
+		}
+
+		return start, placeholders
+	}
+
+	return 0, 0
+}
 
-			return rows
+// anyOf is a sqlmock.Argument that matches any of its values
+type anyOf []driver.Value
+
+// Match implements sqlmock.Argument
+func (a anyOf) Match(v driver.Value) bool {
+	for _, value := range a {
+		if reflect.DeepEqual(value, v) {
+			return true
 		}
+	}
 
-		return rows
-	case "many_to_many":
-		elem := rVal.Type().Elem()
-		scope := &gorm.Scope{Value: reflect.New(elem).Interface()}
-		joinTable := relation.JoinTableHandler.(*gorm.JoinTableHandler)
+	return false
+}
 
-		for _, field := range scope.GetModelStruct().StructFields {
-			if field.IsNormal {
-				columns = append(columns, field.DBName)
+// getPreloadValue returns a copy of value, with blank keys that gorm uses to
+// assign it to its parent filled in. Polymorphic owner columns are always
+// filled in, since gorm filters preload queries on them. Other keys only
+// matter when gorm has to match results against a slice of parents.
+func getPreloadValue(parent reflect.Value, value reflect.Value, relation *gorm.Relationship, isSlice bool) reflect.Value {
+	copied := reflect.New(value.Type())
+	copied.Elem().Set(value)
+
+	scope := &gorm.Scope{Value: copied.Interface()}
+	parentScope := &gorm.Scope{Value: parent.Interface()}
+
+	// fill in keys on dst from src
+	fillKeys := func(dst *gorm.Scope, dstNames []string, src *gorm.Scope, srcNames []string) {
+		for i, name := range dstNames {
+			field, ok := dst.FieldByName(name)
+
+			if !ok || !field.IsBlank {
+				continue
+			}
+
+			if srcField, ok := src.FieldByName(srcNames[i]); ok {
+				field.Set(srcField.Field.Interface())
+			}
+		}
+	}
+
+	switch relation.Kind {
+	case "has_one", "has_many":
+		if relation.PolymorphicType != "" {
+			if field, ok := scope.FieldByName(relation.PolymorphicType); ok && field.IsBlank {
+				field.Set(relation.PolymorphicValue)
 			}
 		}
 
-		for _, key := range joinTable.Source.ForeignKeys {
+		if isSlice || relation.PolymorphicType != "" {
+			fillKeys(scope, relation.ForeignFieldNames, parentScope, relation.AssociationForeignFieldNames)
+		}
+	case "belongs_to":
+		if isSlice {
+			fillKeys(scope, relation.AssociationForeignFieldNames, parentScope, relation.ForeignFieldNames)
+		}
+	}
+
+	return copied.Elem()
+}
+
+// getRelationValues converts the values returned by getPreloadValues to
+// columns and rows for a preload query
+func getRelationValues(field *gorm.StructField, values []preloadValue) ([]string, [][]driver.Value, error) {
+	var (
+		columns []string
+		rows    [][]driver.Value
+	)
+
+	relation := field.Relationship
+	elemType := getElemType(field.Struct.Type)
+
+	for _, field := range (&gorm.Scope{Value: reflect.New(elemType).Interface()}).GetModelStruct().StructFields {
+		if field.IsNormal {
+			columns = append(columns, field.DBName)
+		}
+	}
+
+	var sourceKeys, destKeys []gorm.JoinTableForeignKey
+
+	if relation.Kind == "many_to_many" {
+		sourceKeys = relation.JoinTableHandler.SourceForeignKeys()
+		destKeys = relation.JoinTableHandler.DestinationForeignKeys()
+
+		for _, key := range sourceKeys {
 			columns = append(columns, key.DBName)
 		}
 
-		for _, key := range joinTable.Destination.ForeignKeys {
+		for _, key := range destKeys {
 			columns = append(columns, key.DBName)
 		}
+	}
 
-		rows = sqlmock.NewRows(columns)
+	for _, value := range values {
+		scope := &gorm.Scope{Value: value.value.Interface()}
+		row, err := getRowForFields(scope.Fields())
+
+		if err != nil {
+			return nil, nil, err
+		}
 
-		// we need to check for zero values
-		if reflect.DeepEqual(rVal.Interface(), reflect.New(rVal.Type()).Elem().Interface()) {
-			return rows
+		// need to append the values for join table keys. gorm uses the
+		// source keys to assign each row to its parent.
+		if relation.Kind == "many_to_many" {
+			row = append(row, getJoinTableKeys(&gorm.Scope{Value: value.parent.Interface()}, sourceKeys)...)
+			row = append(row, getJoinTableKeys(scope, destKeys)...)
 		}
 
-		// in this case we definitely have a slice
-		if rVal.Len() > 0 {
-			for i := 0; i < rVal.Len(); i++ {
-				scope := &gorm.Scope{Value: rVal.Index(i).Interface()}
-				row := getRowForFields(scope.Fields())
+		rows = append(rows, row)
+	}
 
-				// need to append the values for join table keys
-				sourcePk := q.scope.PrimaryKeyValue()
-				destModelType := joinTable.Destination.ModelType
-				destModelVal := reflect.New(destModelType).Interface()
-				destPkVal := (&gorm.Scope{Value: destModelVal}).PrimaryKeyValue()
+	return columns, rows, nil
+}
 
-				row = append(row, sourcePk, destPkVal)
+// getJoinTableKeys returns the values of the fields referenced by join table
+// keys, converted to driver values
+func getJoinTableKeys(scope *gorm.Scope, keys []gorm.JoinTableForeignKey) []driver.Value {
+	var values []driver.Value
 
-				rows = rows.AddRow(row...)
-			}
+	for _, key := range keys {
+		var value driver.Value
 
-			return rows
+		if field, ok := scope.FieldByName(key.AssociationDBName); ok {
+			value, _ = getFieldValue(field.Field)
 		}
 
-		return rows
+		values = append(values, value)
+	}
+
+	return values
+}
+
+func (q *SqlmockQueryExpectation) getDestRows(out interface{}) (*sqlmock.Rows, error) {
+	var sql string
+
+	if len(q.parent.recorder.stmts) > 0 {
+		sql = q.parent.recorder.stmts[0].raw
+	}
+
+	return getRows(sql, out)
+}
+
+// getRows converts a value passed to Returns to rows for a query. Models only
+// return the columns selected by sql, and other values have their columns
+// named after the selection.
+func getRows(sql string, out interface{}) (*sqlmock.Rows, error) {
+	columns, rows, err := getDestValues(out)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch indirect(reflect.ValueOf(out)).Kind() {
+	case reflect.Struct, reflect.Slice:
+		columns, rows = selectRows(sql, columns, rows)
 	default:
-		return nil
+		columns = selectNames(sql, columns)
 	}
+
+	return toSqlmockRows(columns, rows), nil
 }
 
-func (q *SqlmockQueryExpectation) getDestRows(out interface{}) *sqlmock.Rows {
-	var columns []string
+// getDestValues converts the value passed to Returns to columns and rows
+func getDestValues(out interface{}) ([]string, [][]driver.Value, error) {
+	var (
+		columns []string
+		rows    [][]driver.Value
+	)
+
 	outVal := indirect(reflect.ValueOf(out))
 
-	if outVal.Kind() == reflect.Slice || outVal.Kind() == reflect.Struct {
+	switch outVal.Kind() {
+	case reflect.Slice, reflect.Struct:
 		for _, field := range (&gorm.Scope{}).New(out).GetModelStruct().StructFields {
 			if field.IsNormal {
 				columns = append(columns, field.DBName)
 			}
 		}
-	} else {
+	case reflect.Map:
+		columns = append(columns, "key", "value")
+	default:
 		columns = append(columns, "count")
 	}
 
-	rows := sqlmock.NewRows(columns)
-
 	// short circuit if we got nil
 	if outVal.Kind() == reflect.Invalid {
-		return rows
+		return columns, rows, nil
 	}
 
 	// SELECT multiple rows
@@ -196,43 +461,117 @@ func (q *SqlmockQueryExpectation) getDestRows(out interface{}) *sqlmock.Rows {
 	case reflect.Slice:
 		outSlice := []interface{}{}
 
+		// slice elements are addressable, so take their address to let
+		// getRowForFields find methods with pointer receivers
 		for i := 0; i < outVal.Len(); i++ {
-			outSlice = append(outSlice, outVal.Index(i).Interface())
+			if elem := outVal.Index(i); elem.Kind() == reflect.Struct {
+				outSlice = append(outSlice, elem.Addr().Interface())
+			} else {
+				outSlice = append(outSlice, elem.Interface())
+			}
 		}
 
 		for _, outElem := range outSlice {
 			scope := &gorm.Scope{Value: outElem}
-			row := getRowForFields(scope.Fields())
-			rows = rows.AddRow(row...)
+			row, err := getRowForFields(scope.Fields())
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			rows = append(rows, row)
 		}
 	case reflect.Struct:
-		row := getRowForFields(q.scope.Fields())
-		rows = rows.AddRow(row...)
+		copied := reflect.New(outVal.Type())
+		copied.Elem().Set(outVal)
+		scope := &gorm.Scope{Value: copied.Interface()}
+		row, err := getRowForFields(scope.Fields())
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rows = append(rows, row)
+	case reflect.Map:
+		// one row per key, sorted so that results are stable
+		keys := outVal.MapKeys()
 
-	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		count, _ := driver.DefaultParameterConverter.ConvertValue(outVal.Interface())
-		rows = rows.AddRow(count)
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		for _, key := range keys {
+			keyValue, err := getFieldValue(key)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Cannot convert key %v to a driver value: %s", key.Interface(), err)
+			}
+
+			value, err := getFieldValue(outVal.MapIndex(key))
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Cannot convert value for key %v to a driver value: %s", key.Interface(), err)
+			}
+
+			rows = append(rows, []driver.Value{keyValue, value})
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+		value, _ := driver.DefaultParameterConverter.ConvertValue(outVal.Interface())
+		rows = append(rows, []driver.Value{value})
 	default:
-		panic(fmt.Errorf("Can only get rows for slice, struct, int/uint, or nil. Got: %s", outVal.Kind()))
+		panic(fmt.Errorf("Can only get rows for slice, struct, map, a single value, or nil. Got: %s", outVal.Kind()))
+	}
+
+	return columns, rows, nil
+}
+
+// toSqlmockRows converts columns and rows to sqlmock.Rows
+func toSqlmockRows(columns []string, values [][]driver.Value) *sqlmock.Rows {
+	rows := sqlmock.NewRows(columns)
+
+	for _, row := range values {
+		rows = rows.AddRow(row...)
 	}
 
 	return rows
 }
 
-// callMethods is used to call deferred db.* methods. It is necessary to ensure scope.Value has a primary key, extracted from the model passed to SqlmockQueryExpectation.Returns. This is because the noop database does not return any actual rows.
+// callMethods is used to call deferred db.* methods. While they are called,
+// the noop database returns rows built from the model passed to
+// SqlmockQueryExpectation.Returns, so that gorm generates the same queries
+// (e.g. for preloading) as it will against the mock database.
 func (q *SqlmockQueryExpectation) callMethods() {
 	q.parent.gorm = q.parent.gorm.Set("gorm_expect:ret", q.scope.Value)
-	q.parent.gorm.Callback().Query().Before("gorm:preload").Register("gorm_expect:populate_scope_val", populateScopeValueCallback)
+
+	defer func() {
+		q.parent.gorm = q.parent.gorm.Set("gorm_expect:ret", nil)
+	}()
 
 	noop := reflect.ValueOf(q.parent.gorm)
 	for methodName, args := range q.parent.callmap {
 		methodVal := noop.MethodByName(methodName)
+		out := args[0]
+
+		// FirstOrInit initialises out, just like gorm does. Otherwise, out is
+		// copied so that scanning rows never modifies values owned by tests.
+		if methodName != "FirstOrInit" {
+			out = copyOut(out)
+		}
 
 		switch method := methodVal.Interface().(type) {
 		case func(interface{}) *gorm.DB:
-			method(args[0])
+			method(out)
 		case func(interface{}, ...interface{}) *gorm.DB:
-			method(args[0], args[1:]...)
+			method(out, args[1:]...)
+		case func() (*sql.Rows, error):
+			if rows, err := method(); err == nil {
+				rows.Close()
+			}
+		case func() *sql.Row:
+			// scanning closes the row, there is nothing to scan into
+			method().Scan()
 		default:
 			fmt.Println("Not a supported method signature")
 		}
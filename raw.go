@@ -0,0 +1,131 @@
+package gormexpect
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// RawQueryExpectation is returned by Query. Unlike SqlmockQueryExpectation,
+// its SQL is given by the test instead of being recorded from gorm.
+type RawQueryExpectation struct {
+	parent *Expecter
+	stmt   Stmt
+}
+
+// RawExecExpectation is returned by ExecSQL
+type RawExecExpectation struct {
+	parent *Expecter
+	stmt   Stmt
+}
+
+// Query expects a query without mirroring how it is built, for code paths
+// too dynamic for the fluent API. sql is the exact statement, unless another
+// QueryMatcher is set with MatchWith, e.g. QueryMatcherRegexp to give a
+// regular expression. If args are given, the query must be executed with
+// them (sqlmock.AnyArg() matches anything). Returns converts models to rows
+// just like the fluent API does. If sql can be read as SQL, rows are
+// restricted to its selected columns, which is not the case for regular
+// expressions with escapes.
+func (h *Expecter) Query(sql string, args ...interface{}) QueryExpectation {
+	return &RawQueryExpectation{parent: h, stmt: h.rawStmt("query", sql, args)}
+}
+
+// ExecSQL expects a statement executed with db.Exec or by gorm, given as the
+// exact statement unless another QueryMatcher is set, like Query.
+func (h *Expecter) ExecSQL(sql string, args ...interface{}) ExecExpectation {
+	return &RawExecExpectation{parent: h, stmt: h.rawStmt("exec", sql, args)}
+}
+
+// Returns sets the rows returned by the query from a struct, slice, map or
+// single value, in the same way as SqlmockQueryExpectation.Returns. Preloads
+// are not expected, since they are separate queries.
+func (q *RawQueryExpectation) Returns(value interface{}) *Expecter {
+	// rows are built using scopes without a DB, which can only get model
+	// structs that gorm has already cached
+	if value != nil {
+		q.parent.gorm.NewScope(value).GetModelStruct()
+	}
+
+	queryer := q.parent.adapter.ExpectQuery(q.stmt)
+
+	if len(q.stmt.args) > 0 {
+		queryer = queryer.Args(q.stmt.matchArgs()...)
+	}
+
+	rows, err := getRows(q.stmt.raw, value)
+
+	// the statement fails with rows that can't be built, which is also
+	// reported by AssertExpectations
+	if err != nil {
+		addExpectError(q.parent.gorm, err)
+		queryer.Errors(err)
+
+		return q.parent
+	}
+
+	queryer.Returns(rows)
+
+	return q.parent
+}
+
+// WillSucceed sets the result of the statement
+func (e *RawExecExpectation) WillSucceed(lastReturnedID, rowsAffected int64) ExecExpectation {
+	e.execer().WillSucceed(lastReturnedID, rowsAffected)
+
+	return e
+}
+
+// WillFail makes the statement fail with err
+func (e *RawExecExpectation) WillFail(err error) ExecExpectation {
+	e.execer().WillFail(err)
+
+	return e
+}
+
+func (e *RawExecExpectation) execer() Execer {
+	execer := e.parent.adapter.ExpectExec(e.stmt)
+
+	if len(e.stmt.args) > 0 {
+		execer = execer.Args(e.stmt.matchArgs()...)
+	}
+
+	return execer
+}
+
+// rawStmt builds a Stmt from SQL given by a test, matched by the
+// QueryMatcher set with MatchWith if there is one. Like executed statements,
+// it is put in canonical form, so its columns and args may be in any order.
+func (h *Expecter) rawStmt(kind, sql string, args []interface{}) Stmt {
+	stmt := canonicalStmt(Stmt{kind: kind, sql: sql, args: args})
+	pattern := sqlPattern(stmt.sql)
+
+	if m := h.matcher(); m != nil {
+		var err error
+
+		if pattern, err = m.Pattern(stmt.sql); err != nil {
+			panic(fmt.Errorf("Cannot match %s: %s", sql, err))
+		}
+	}
+
+	stmt.sql = pattern
+
+	return stmt
+}
+
+// sqlPattern returns a regular expression matching exactly sql, taken
+// literally. Use MatchWith(QueryMatcherRegexp) for a regular expression.
+func sqlPattern(sql string) string {
+	pattern, _ := exactPattern(sql)
+
+	return pattern
+}
+
+func toDriverValues(args []interface{}) []driver.Value {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		values[i] = arg
+	}
+
+	return values
+}
@@ -0,0 +1,107 @@
+package gormexpect_test
+
+import (
+	"errors"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestRawQuery(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []User{}
+	out := []User{User{Id: 1, Name: "jinzhu"}, User{Id: 2, Name: "jinzhu"}}
+
+	expect.Query("SELECT * FROM users WHERE name = ?", "jinzhu").Returns(out)
+	db.Raw("SELECT * FROM users WHERE name = ?", "jinzhu").Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
+
+func TestRawQueryRegex(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{}
+	out := User{Id: 1, Name: "jinzhu", Age: 20}
+
+	expect.MatchWith(expecter.QueryMatcherRegexp).Query(`SELECT id, name FROM "users" WHERE .+ LIMIT 1`, sqlmock.AnyArg()).Returns(out)
+	db.Select("id, name").Where("name = ?", "jinzhu").First(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, User{Id: 1, Name: "jinzhu"}, in)
+}
+
+func TestRawQueryLiteral(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []User{}
+
+	// without a QueryMatcher, sql is neither a regular expression nor a prefix
+	expect.Query(`SELECT * FROM users WHERE .+`).Returns([]User{})
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE name = ?", "jinzhu").Scan(&in).Error)
+	db.Raw(`SELECT * FROM users WHERE .+`).Scan(&in)
+
+	expect.Query("SELECT * FROM users").Returns([]User{})
+	assert.NotNil(t, db.Raw("SELECT * FROM users WHERE name = ?", "jinzhu").Scan(&in).Error)
+	db.Raw("SELECT * FROM users").Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestRawQueryArgs(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.Query("SELECT * FROM users WHERE name = ?", "jinzhu").Returns(User{})
+	err = db.Raw("SELECT * FROM users WHERE name = ?", "itwx").Scan(&User{}).Error
+
+	assert.NotNil(t, err)
+
+	db.Raw("SELECT * FROM users WHERE name = ?", "jinzhu").Scan(&User{})
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestExecSQL(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.ExecSQL("UPDATE users SET name = ? WHERE id = ?", "jinzhu", 1).WillSucceed(0, 1)
+	result := db.Exec("UPDATE users SET name = ? WHERE id = ?", "jinzhu", 1)
+
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(1), result.RowsAffected)
+
+	failure := errors.New("deadlock")
+
+	expect.ExecSQL("DELETE FROM users WHERE id = ?", 1).WillFail(failure)
+	assert.Equal(t, failure, db.Exec("DELETE FROM users WHERE id = ?", 1).Error)
+
+	assert.Nil(t, expect.AssertExpectations())
+}
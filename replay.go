@@ -0,0 +1,591 @@
+package gormexpect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// NewRecordReplayAdapter returns an AdapterFactory for a RecordReplayAdapter
+// that keeps its fixture at path. If the fixture does not exist yet, or the
+// tests run with -update or GORMEXPECT_UPDATE (see AssertGolden), it records:
+// the returned gorm.DB proxies to a real database (opened like gorm.Open), and
+// every statement is saved to the fixture along with its results. Otherwise,
+// it replays: the returned gorm.DB is backed by go-sqlmock, and expects the
+// statements in the fixture.
+//
+// For example, with a local SQLite database:
+//
+//	db, expect, err := NewExpecter(NewRecordReplayAdapter("testdata/users.json"), "sqlite3", "test.db")
+func NewRecordReplayAdapter(path string) AdapterFactory {
+	return func(dialect string, args ...interface{}) (*gorm.DB, Adapter, error) {
+		if _, err := os.Stat(path); updating() || os.IsNotExist(err) {
+			return newRecordingAdapter(path, dialect, args...)
+		}
+
+		return newReplayingAdapter(path, dialect)
+	}
+}
+
+// RecordReplayAdapter implements Adapter. While replaying, it behaves like
+// SqlmockAdapter after expecting every statement in the fixture, so further
+// expectations may still be set. While recording, there is no mock database,
+// so expectations are ignored.
+type RecordReplayAdapter struct {
+	*SqlmockAdapter
+	fixture *fixture
+}
+
+func newRecordingAdapter(path, dialect string, args ...interface{}) (*gorm.DB, Adapter, error) {
+	var driverName, source string
+
+	switch {
+	case len(args) == 1:
+		driverName = dialect
+		source, _ = args[0].(string)
+	case len(args) >= 2:
+		driverName, _ = args[0].(string)
+		source, _ = args[1].(string)
+	}
+
+	if source == "" {
+		return nil, nil, fmt.Errorf("Recording needs a driver and data source, got: %v", args)
+	}
+
+	// open the real database to get hold of its driver
+	realDb, err := sql.Open(driverName, source)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := &fixture{path: path}
+	recordingDb := sql.OpenDB(&recordingConnector{
+		drv:    &recordingDriver{Driver: realDb.Driver(), fixture: f},
+		source: source,
+	})
+	realDb.Close()
+
+	// save an empty fixture, so that nothing is left over from a previous
+	// recording if no statements are executed
+	if err := f.save(); err != nil {
+		return nil, nil, err
+	}
+
+	gormDb, err := gorm.Open(dialect, recordingDb)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gormDb, &RecordReplayAdapter{fixture: f}, nil
+}
+
+func newReplayingAdapter(path, dialect string) (*gorm.DB, Adapter, error) {
+	f := &fixture{path: path}
+
+	if err := f.load(); err != nil {
+		return nil, nil, err
+	}
+
+	// a pool of its own, so that closing the returned DB leaves the one
+	// shared by other adapters open
+	gormDb, err := gorm.Open(dialect, openCanonicalDB())
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	adapter := &RecordReplayAdapter{SqlmockAdapter: &SqlmockAdapter{db: db, mocker: mock}}
+
+	if err := adapter.expectFixture(f); err != nil {
+		return nil, nil, err
+	}
+
+	return gormDb, adapter, nil
+}
+
+// IsRecording returns true if the adapter is recording a new fixture
+func (a *RecordReplayAdapter) IsRecording() bool {
+	return a.SqlmockAdapter == nil
+}
+
+// ExpectQuery implements Adapter
+func (a *RecordReplayAdapter) ExpectQuery(stmt Stmt) Queryer {
+	if a.IsRecording() {
+		return &ignoredQueryer{}
+	}
+
+	return a.SqlmockAdapter.ExpectQuery(stmt)
+}
+
+// ExpectExec implements Adapter
+func (a *RecordReplayAdapter) ExpectExec(stmt Stmt) Execer {
+	if a.IsRecording() {
+		return &ignoredExecer{}
+	}
+
+	return a.SqlmockAdapter.ExpectExec(stmt)
+}
+
+// ExpectBegin implements Adapter
+func (a *RecordReplayAdapter) ExpectBegin() TxBeginner {
+	if a.IsRecording() {
+		return &ignoredTxBeginner{}
+	}
+
+	return a.SqlmockAdapter.ExpectBegin()
+}
+
+// ExpectCommit implements Adapter
+func (a *RecordReplayAdapter) ExpectCommit() TxCommitter {
+	if a.IsRecording() {
+		return &ignoredTxCommitter{}
+	}
+
+	return a.SqlmockAdapter.ExpectCommit()
+}
+
+// ExpectRollback implements Adapter
+func (a *RecordReplayAdapter) ExpectRollback() TxRollback {
+	if a.IsRecording() {
+		return &ignoredTxRollback{}
+	}
+
+	return a.SqlmockAdapter.ExpectRollback()
+}
+
+// AssertExpectations implements Adapter. While recording, it returns the
+// error from saving the fixture, if any.
+func (a *RecordReplayAdapter) AssertExpectations() error {
+	if a.IsRecording() {
+		return a.fixture.err()
+	}
+
+	return a.SqlmockAdapter.AssertExpectations()
+}
+
+// expectFixture expects every statement in the fixture, in order
+func (a *RecordReplayAdapter) expectFixture(f *fixture) error {
+	for _, stmt := range f.statements {
+		var stmtErr error
+
+		if stmt.Error != "" {
+			stmtErr = errors.New(stmt.Error)
+		}
+
+		switch stmt.Kind {
+		case "begin":
+			expectation := a.mocker.ExpectBegin()
+
+			if stmtErr != nil {
+				expectation.WillReturnError(stmtErr)
+			}
+		case "commit":
+			expectation := a.mocker.ExpectCommit()
+
+			if stmtErr != nil {
+				expectation.WillReturnError(stmtErr)
+			}
+		case "rollback":
+			expectation := a.mocker.ExpectRollback()
+
+			if stmtErr != nil {
+				expectation.WillReturnError(stmtErr)
+			}
+		case "query":
+			pattern, args := replayStatement(stmt)
+			expectation := a.mocker.ExpectQuery(pattern).WithArgs(args...)
+
+			if stmtErr != nil {
+				expectation.WillReturnError(stmtErr)
+				continue
+			}
+
+			rows := sqlmock.NewRows(stmt.Columns)
+
+			for _, row := range stmt.Rows {
+				rows = rows.AddRow(fixtureValues(row)...)
+			}
+
+			expectation.WillReturnRows(rows)
+		case "exec":
+			pattern, args := replayStatement(stmt)
+			expectation := a.mocker.ExpectExec(pattern).WithArgs(args...)
+
+			if stmtErr != nil {
+				expectation.WillReturnError(stmtErr)
+				continue
+			}
+
+			expectation.WillReturnResult(sqlmock.NewResult(stmt.LastInsertID, stmt.RowsAffected))
+		default:
+			return fmt.Errorf("Unknown statement kind in fixture %s: %s", f.path, stmt.Kind)
+		}
+	}
+
+	return nil
+}
+
+// replayStatement returns the pattern and args that a recorded statement is
+// expected with. The statements executed while replaying are put in canonical
+// form, so the recorded one is too.
+func replayStatement(stmt fixtureStatement) (string, []driver.Value) {
+	canonical, order := canonicalStatement(stmt.SQL)
+
+	return regexp.QuoteMeta(canonical), reorder(replayArgs(stmt.Args), order)
+}
+
+// replayArgs converts recorded args to sqlmock args. Times (e.g. UpdatedAt)
+// are different every time a test runs, so any time is accepted.
+func replayArgs(args []fixtureValue) []driver.Value {
+	return anyTimes(fixtureValues(args))
+}
+
+// fixture is the list of statements recorded to, or replayed from, a file
+type fixture struct {
+	sync.Mutex
+	path       string
+	statements []fixtureStatement
+	saveErr    error
+}
+
+type fixtureStatement struct {
+	Kind         string           `json:"kind"` // query, exec, begin, commit or rollback
+	SQL          string           `json:"sql,omitempty"`
+	Args         []fixtureValue   `json:"args,omitempty"`
+	Columns      []string         `json:"columns,omitempty"`
+	Rows         [][]fixtureValue `json:"rows,omitempty"`
+	LastInsertID int64            `json:"last_insert_id,omitempty"`
+	RowsAffected int64            `json:"rows_affected,omitempty"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// record appends stmt and saves the fixture, so that it is complete even if
+// the test never calls AssertExpectations
+func (f *fixture) record(stmt fixtureStatement, err error) {
+	if err != nil {
+		stmt.Error = err.Error()
+	}
+
+	f.Lock()
+	f.statements = append(f.statements, stmt)
+	f.Unlock()
+
+	if saveErr := f.save(); saveErr != nil {
+		f.Lock()
+		f.saveErr = saveErr
+		f.Unlock()
+	}
+}
+
+func (f *fixture) err() error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.saveErr
+}
+
+func (f *fixture) save() error {
+	f.Lock()
+	defer f.Unlock()
+
+	statements := f.statements
+
+	if statements == nil {
+		statements = []fixtureStatement{}
+	}
+
+	data, err := json.MarshalIndent(statements, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f.path, append(data, '\n'), 0644)
+}
+
+func (f *fixture) load() error {
+	data, err := ioutil.ReadFile(f.path)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &f.statements)
+}
+
+// fixtureValue is a driver.Value that keeps its type when encoded as JSON
+type fixtureValue struct {
+	value driver.Value
+}
+
+type encodedFixtureValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (v fixtureValue) MarshalJSON() ([]byte, error) {
+	var typeName string
+
+	switch value := v.value.(type) {
+	case nil:
+		return json.Marshal(encodedFixtureValue{Type: "null"})
+	case int64:
+		typeName = "int64"
+	case float64:
+		typeName = "float64"
+	case bool:
+		typeName = "bool"
+	case string:
+		typeName = "string"
+	case []byte:
+		typeName = "bytes"
+	case time.Time:
+		typeName = "time"
+		v.value = value.Format(time.RFC3339Nano)
+	default:
+		return nil, fmt.Errorf("Cannot record value of type %T", v.value)
+	}
+
+	data, err := json.Marshal(v.value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encodedFixtureValue{Type: typeName, Value: data})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *fixtureValue) UnmarshalJSON(data []byte) error {
+	var encoded encodedFixtureValue
+
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	var err error
+
+	switch encoded.Type {
+	case "null":
+		v.value = nil
+	case "int64":
+		var value int64
+		err = json.Unmarshal(encoded.Value, &value)
+		v.value = value
+	case "float64":
+		var value float64
+		err = json.Unmarshal(encoded.Value, &value)
+		v.value = value
+	case "bool":
+		var value bool
+		err = json.Unmarshal(encoded.Value, &value)
+		v.value = value
+	case "string":
+		var value string
+		err = json.Unmarshal(encoded.Value, &value)
+		v.value = value
+	case "bytes":
+		var value []byte
+		err = json.Unmarshal(encoded.Value, &value)
+		v.value = value
+	case "time":
+		var value string
+
+		if err = json.Unmarshal(encoded.Value, &value); err == nil {
+			v.value, err = time.Parse(time.RFC3339Nano, value)
+		}
+	default:
+		err = fmt.Errorf("Unknown value type in fixture: %s", encoded.Type)
+	}
+
+	return err
+}
+
+func toFixtureValues(values []driver.Value) []fixtureValue {
+	converted := make([]fixtureValue, len(values))
+
+	for i, value := range values {
+		// drivers may reuse byte slices
+		if b, ok := value.([]byte); ok {
+			value = append([]byte{}, b...)
+		}
+
+		converted[i] = fixtureValue{value}
+	}
+
+	return converted
+}
+
+func fixtureValues(values []fixtureValue) []driver.Value {
+	converted := make([]driver.Value, len(values))
+
+	for i, value := range values {
+		converted[i] = value.value
+	}
+
+	return converted
+}
+
+// recordingConnector implements driver.Connector for recordingDriver
+type recordingConnector struct {
+	drv    *recordingDriver
+	source string
+}
+
+func (c *recordingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.source)
+}
+
+func (c *recordingConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// recordingDriver wraps a real driver and records every statement executed
+// through it to a fixture
+type recordingDriver struct {
+	driver.Driver
+	fixture *fixture
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingConn{conn: conn, fixture: d.fixture}, nil
+}
+
+type recordingConn struct {
+	conn    driver.Conn
+	fixture *fixture
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.conn.Prepare(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingStmt{stmt: stmt, query: query, fixture: c.fixture}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	tx, err := c.conn.Begin()
+	c.fixture.record(fixtureStatement{Kind: "begin"}, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingTx{tx: tx, fixture: c.fixture}, nil
+}
+
+type recordingTx struct {
+	tx      driver.Tx
+	fixture *fixture
+}
+
+func (t *recordingTx) Commit() error {
+	err := t.tx.Commit()
+	t.fixture.record(fixtureStatement{Kind: "commit"}, err)
+
+	return err
+}
+
+func (t *recordingTx) Rollback() error {
+	err := t.tx.Rollback()
+	t.fixture.record(fixtureStatement{Kind: "rollback"}, err)
+
+	return err
+}
+
+type recordingStmt struct {
+	stmt    driver.Stmt
+	query   string
+	fixture *fixture
+}
+
+func (s *recordingStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *recordingStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	stmt := fixtureStatement{Kind: "exec", SQL: s.query, Args: toFixtureValues(args)}
+	result, err := s.stmt.Exec(args)
+
+	if err == nil {
+		stmt.LastInsertID, _ = result.LastInsertId()
+		stmt.RowsAffected, _ = result.RowsAffected()
+	}
+
+	s.fixture.record(stmt, err)
+
+	return result, err
+}
+
+// Query reads all rows, so that they can be recorded before they are returned
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	stmt := fixtureStatement{Kind: "query", SQL: s.query, Args: toFixtureValues(args)}
+	rows, err := s.stmt.Query(args)
+
+	if err != nil {
+		s.fixture.record(stmt, err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	stmt.Columns = rows.Columns()
+	var values [][]driver.Value
+
+	for {
+		row := make([]driver.Value, len(stmt.Columns))
+
+		if err = rows.Next(row); err != nil {
+			break
+		}
+
+		stmt.Rows = append(stmt.Rows, toFixtureValues(row))
+		values = append(values, fixtureValues(stmt.Rows[len(stmt.Rows)-1]))
+	}
+
+	if err != io.EOF {
+		s.fixture.record(stmt, err)
+		return nil, err
+	}
+
+	s.fixture.record(stmt, nil)
+
+	return NewNoopRows(stmt.Columns, values), nil
+}
@@ -0,0 +1,79 @@
+package gormexpect_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorm_expect")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fixture := filepath.Join(dir, "emails.json")
+	source := filepath.Join(dir, "test.db")
+
+	run := func(db *gorm.DB) []Email {
+		var emails []Email
+
+		db.AutoMigrate(&Email{})
+		db.Create(&Email{UserId: 1, Email: "jinzhu@example.org"})
+		db.Where("user_id = ?", 1).Find(&emails)
+
+		return emails
+	}
+
+	// the fixture does not exist, so the real database is used
+	db, expect, err := expecter.NewExpecter(expecter.NewRecordReplayAdapter(fixture), "sqlite3", source)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := run(db)
+	db.Close()
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, 1, len(recorded))
+	assert.Equal(t, "jinzhu@example.org", recorded[0].Email)
+
+	// the database is gone, so the results can only come from the fixture
+	assert.Nil(t, os.Remove(source))
+
+	db, expect, err = expecter.NewExpecter(expecter.NewRecordReplayAdapter(fixture), "sqlite3", source)
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := run(db)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, recorded, replayed)
+
+	// further expectations are matched in canonical form, as they are with
+	// the default adapter
+	email := Email{UserId: 2, Email: "jinzhu@example.com"}
+
+	expect.Begin()
+	expect.Create(&email).WillSucceed(2, 1)
+	expect.Commit()
+
+	assert.Nil(t, db.Create(&email).Error)
+	assert.Nil(t, expect.AssertExpectations())
+
+	_, err = os.Stat(source)
+	assert.True(t, os.IsNotExist(err))
+}
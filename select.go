@@ -0,0 +1,191 @@
+package gormexpect
+
+import (
+	"database/sql/driver"
+	"strings"
+)
+
+// selectColumn is a single item of a SELECT list
+type selectColumn struct {
+	name   string // the column name the database would return
+	source string // the selected column, if name is an alias for one
+	all    bool   // * or table.*
+}
+
+// parseSelectColumns returns the items of a statement's SELECT list. false is
+// returned if the statement is not a SELECT or cannot be tokenized.
+func parseSelectColumns(sql string) ([]selectColumn, bool) {
+	tokens, err := tokenize(sql)
+
+	if err != nil || len(tokens) == 0 || !tokens[0].is("SELECT") {
+		return nil, false
+	}
+
+	tokens = tokens[1:]
+
+	if len(tokens) > 0 && (tokens[0].is("DISTINCT") || tokens[0].is("ALL")) {
+		tokens = tokens[1:]
+	}
+
+	var (
+		columns []selectColumn
+		item    []token
+		depth   int
+	)
+
+	for _, tok := range tokens {
+		if depth == 0 && (tok.is(",") || tok.is("FROM")) {
+			columns = append(columns, parseSelectItem(sql, item))
+			item = nil
+
+			if tok.is("FROM") {
+				return columns, true
+			}
+
+			continue
+		}
+
+		depth += parenDepth(tok)
+		item = append(item, tok)
+	}
+
+	if len(item) > 0 {
+		columns = append(columns, parseSelectItem(sql, item))
+	}
+
+	return columns, true
+}
+
+// parseSelectItem names a single item of a SELECT list, such as
+// "users"."name", count(*) AS total or name username
+func parseSelectItem(sql string, item []token) selectColumn {
+	if len(item) == 0 {
+		return selectColumn{}
+	}
+
+	var alias string
+	last := item[len(item)-1]
+
+	if len(item) > 2 && item[len(item)-2].is("AS") {
+		alias = last.text
+		item = item[:len(item)-2]
+	} else if len(item) > 1 && isName(last) && !last.is("END") {
+		if prev := item[len(item)-2]; prev.kind != tokenOperator && !prev.is(".") {
+			alias = last.text
+			item = item[:len(item)-1]
+		}
+	}
+
+	last = item[len(item)-1]
+
+	if last.is("*") && (len(item) == 1 || (len(item) == 3 && item[1].is("."))) {
+		return selectColumn{all: true}
+	}
+
+	var source string
+
+	// a plain or table qualified column
+	if isName(last) && (len(item) == 1 || (len(item) == 3 && item[1].is(".") && isName(item[0]))) {
+		source = last.text
+	}
+
+	if alias != "" {
+		return selectColumn{name: alias, source: source}
+	}
+
+	if source != "" {
+		return selectColumn{name: source}
+	}
+
+	// databases disagree on how computed columns are named, so use the
+	// expression itself. It won't match a field unless it is aliased.
+	return selectColumn{name: sql[item[0].pos:last.end]}
+}
+
+func isName(tok token) bool {
+	return tok.kind == tokenWord || tok.kind == tokenIdent
+}
+
+// selectRows restricts columns and rows built from a model to the columns
+// selected by sql, in the order they were selected. Aliases are looked up by
+// their own name first, so that values can be given by a field named after
+// the alias, and then by the column they alias. Columns that match no field,
+// such as an unaliased COUNT(*), are returned as NULL.
+func selectRows(sql string, columns []string, rows [][]driver.Value) ([]string, [][]driver.Value) {
+	selected, ok := parseSelectColumns(sql)
+
+	if !ok || len(selected) == 0 {
+		return columns, rows
+	}
+
+	index := make(map[string]int)
+
+	for i, column := range columns {
+		index[strings.ToLower(column)] = i
+	}
+
+	var (
+		selectedColumns []string
+		positions       []int
+	)
+
+	for _, column := range selected {
+		if column.all {
+			for i, name := range columns {
+				selectedColumns = append(selectedColumns, name)
+				positions = append(positions, i)
+			}
+
+			continue
+		}
+
+		position := -1
+
+		if i, ok := index[strings.ToLower(column.name)]; ok {
+			position = i
+		} else if i, ok := index[strings.ToLower(column.source)]; ok && column.source != "" {
+			position = i
+		}
+
+		selectedColumns = append(selectedColumns, column.name)
+		positions = append(positions, position)
+	}
+
+	selectedRows := make([][]driver.Value, len(rows))
+
+	for i, row := range rows {
+		selectedRow := make([]driver.Value, len(positions))
+
+		for j, position := range positions {
+			if position >= 0 && position < len(row) {
+				selectedRow[j] = row[position]
+			}
+		}
+
+		selectedRows[i] = selectedRow
+	}
+
+	return selectedColumns, selectedRows
+}
+
+// selectNames renames columns after the items selected by sql, if there is
+// one for each column
+func selectNames(sql string, columns []string) []string {
+	selected, ok := parseSelectColumns(sql)
+
+	if !ok || len(selected) != len(columns) {
+		return columns
+	}
+
+	names := make([]string, len(selected))
+
+	for i, column := range selected {
+		if column.all {
+			return columns
+		}
+
+		names[i] = column.name
+	}
+
+	return names
+}
@@ -0,0 +1,240 @@
+package gormexpect_test
+
+import (
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+)
+
+type UserTotal struct {
+	Name  string
+	Total int64
+}
+
+type UserName struct {
+	Username string
+}
+
+func TestSelect(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []User{}
+	out := []User{User{Id: 1, Name: "jinzhu", Age: 20}, User{Id: 2, Name: "itwx", Age: 30}}
+
+	expect.Select("id, name").Find(&in).Returns(out)
+	db.Select("id, name").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, []User{User{Id: 1, Name: "jinzhu"}, User{Id: 2, Name: "itwx"}}, in)
+}
+
+func TestSelectQualified(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{}
+	out := User{Id: 1, Name: "jinzhu", Age: 20}
+
+	expect.Select(`"users"."id", users.age`).First(&in).Returns(out)
+	db.Select(`"users"."id", users.age`).First(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, User{Id: 1, Age: 20}, in)
+}
+
+func TestSelectAlias(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []UserName{}
+	out := []UserName{UserName{Username: "jinzhu"}}
+
+	expect.Select("name AS username").Find(&in).Returns(out)
+	db.Select("name AS username").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
+
+func TestSelectComputed(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []UserTotal{}
+	out := []UserTotal{UserTotal{Name: "jinzhu", Total: 2}, UserTotal{Name: "itwx", Total: 1}}
+
+	expect.Select("name, COUNT(*) AS total").Find(&in).Returns(out)
+	db.Select("name, COUNT(*) AS total").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+
+	// without an alias, the count does not match a field
+	in = []UserTotal{}
+
+	expect.Select("name, COUNT(*)").Find(&in).Returns(out)
+	db.Select("name, COUNT(*)").Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, []UserTotal{UserTotal{Name: "jinzhu"}, UserTotal{Name: "itwx"}}, in)
+}
+
+type UserSummary struct {
+	Name    string
+	Age     int64
+	Contact string `gorm:"column:email"`
+	Ignored string
+}
+
+func TestScan(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := UserSummary{}
+	out := UserSummary{Name: "jinzhu", Age: 20, Contact: "jinzhu@example.org", Ignored: "ignored"}
+
+	expect.Model(&User{}).Select("name, age, email").Where("id = ?", 1).Scan(&in).Returns(out)
+	db.Model(&User{}).Select("name, age, email").Where("id = ?", 1).Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, UserSummary{Name: "jinzhu", Age: 20, Contact: "jinzhu@example.org"}, in)
+}
+
+func TestScanTable(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []UserTotal{}
+	out := []UserTotal{UserTotal{Name: "jinzhu", Total: 2}}
+
+	expect.Table("users").Select("name, COUNT(*) AS total").Scan(&in).Returns(out)
+	db.Table("users").Select("name, COUNT(*) AS total").Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
+
+type StatusCount struct {
+	Status string
+	Total  int64
+}
+
+func TestGroupScan(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []StatusCount{}
+	out := []StatusCount{StatusCount{Status: "paid", Total: 3}, StatusCount{Status: "pending", Total: 1}}
+
+	expect.Table("orders").Select("status, count(*) AS total").Group("status").Having("count(*) > ?", 0).Scan(&in).Returns(out)
+	db.Table("orders").Select("status, count(*) AS total").Group("status").Having("count(*) > ?", 0).Scan(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
+
+func TestGroupRowsMap(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.Table("orders").Select("status, count(*)").Group("status").Rows().Returns(map[string]int{"pending": 1, "paid": 3})
+
+	rows, err := db.Table("orders").Select("status, count(*)").Group("status").Rows()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	columns, _ := rows.Columns()
+	assert.Equal(t, []string{"status", "count(*)"}, columns)
+
+	counts := map[string]int{}
+
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+
+		assert.Nil(t, rows.Scan(&status, &count))
+		counts[status] = count
+	}
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, map[string]int{"pending": 1, "paid": 3}, counts)
+}
+
+func TestRowSum(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect.Table("orders").Select("sum(price)").Where("status = ?", "paid").Row().Returns(42.5)
+
+	var total float64
+	err = db.Table("orders").Select("sum(price)").Where("status = ?", "paid").Row().Scan(&total)
+
+	assert.Nil(t, err)
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, 42.5, total)
+}
+
+func TestSelectSubscript(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// brackets are subscripts, not quoted names
+	expect.Query(`SELECT id, tags[1], ARRAY[name, email] AS contact FROM "users"`).Returns([]User{User{Id: 1}})
+	rows, err := db.Raw(`SELECT id, tags[1], ARRAY[name, email] AS contact FROM "users"`).Rows()
+
+	if assert.Nil(t, err) {
+		columns, _ := rows.Columns()
+		assert.Equal(t, []string{"id", "tags[1]", "contact"}, columns)
+		rows.Close()
+	}
+
+	assert.Nil(t, expect.AssertExpectations())
+}
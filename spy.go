@@ -0,0 +1,130 @@
+package gormexpect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Execution is a statement that was run through the callbacks of the gorm.DB
+// returned by NewDefaultExpecter or NewExpecter
+type Execution struct {
+	Kind         string // "query" or "exec"
+	Table        string
+	SQL          string
+	Args         []interface{}
+	Duration     time.Duration
+	RowsAffected int64
+	Error        error
+}
+
+// String formats the execution the way budget errors list it
+func (e Execution) String() string {
+	return fmt.Sprintf("%s: %s %v", e.Kind, e.SQL, e.Args)
+}
+
+// Spy records the statements that a gorm.DB runs through its callbacks, which
+// db.Exec and transactions don't use. Unlike Recorder, it may be used by the
+// code under test from multiple goroutines.
+type Spy struct {
+	sync.Mutex
+	executed []Execution
+}
+
+// Executed returns the statements executed so far
+func (s *Spy) Executed() []Execution {
+	s.Lock()
+	defer s.Unlock()
+
+	executed := make([]Execution, len(s.executed))
+	copy(executed, s.executed)
+
+	return executed
+}
+
+// checkBudget returns an error listing the executions if there are more
+// than n of them
+func checkBudget(executed []Execution, n int, on string) error {
+	if len(executed) <= n {
+		return nil
+	}
+
+	msg := bytes.NewBufferString("")
+	fmt.Fprintf(msg, "expected at most %d queries%s, but %d were executed:", n, on, len(executed))
+
+	for i, execution := range executed {
+		fmt.Fprintf(msg, "\n  %d. %s", i+1, execution)
+	}
+
+	return errors.New(msg.String())
+}
+
+func (s *Spy) record(execution Execution) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.executed = append(s.executed, execution)
+}
+
+// spyOn registers callbacks on db that record each statement to a new Spy
+func spyOn(db *gorm.DB) (*gorm.DB, *Spy) {
+	spy := &Spy{}
+	db = db.Set("gorm_expect:spy", spy)
+
+	db.Callback().Create().Before("gorm:create").Register("gorm_expect:spy_start", spyStartCallback)
+	db.Callback().Create().After("gorm:create").Register("gorm_expect:spy_exec", spyExecCallback)
+	db.Callback().Query().Before("gorm:query").Register("gorm_expect:spy_start", spyStartCallback)
+	db.Callback().Query().After("gorm:query").Register("gorm_expect:spy_query", spyQueryCallback)
+	db.Callback().RowQuery().Before("gorm:row_query").Register("gorm_expect:spy_start", spyStartCallback)
+	db.Callback().RowQuery().After("gorm:row_query").Register("gorm_expect:spy_row_query", spyQueryCallback)
+	db.Callback().Update().Before("gorm:update").Register("gorm_expect:spy_start", spyStartCallback)
+	db.Callback().Update().After("gorm:update").Register("gorm_expect:spy_update", spyExecCallback)
+	db.Callback().Delete().Before("gorm:delete").Register("gorm_expect:spy_start", spyStartCallback)
+	db.Callback().Delete().After("gorm:delete").Register("gorm_expect:spy_delete", spyExecCallback)
+
+	return db, spy
+}
+
+func spyStartCallback(scope *gorm.Scope) {
+	scope.InstanceSet("gorm_expect:spy_started", time.Now())
+}
+
+func spyExecCallback(scope *gorm.Scope) {
+	spyRecord(scope, "exec")
+}
+
+func spyQueryCallback(scope *gorm.Scope) {
+	spyRecord(scope, "query")
+}
+
+func spyRecord(scope *gorm.Scope, kind string) {
+	s, ok := scope.Get("gorm_expect:spy")
+
+	if !ok {
+		panic(fmt.Errorf("Expected a spy to be set, but got none"))
+	}
+
+	// nothing was executed, e.g. an UPDATE without any changes
+	if scope.SQL == "" {
+		return
+	}
+
+	execution := Execution{
+		Kind:         kind,
+		Table:        scope.TableName(),
+		SQL:          scope.SQL,
+		Args:         scope.SQLVars,
+		RowsAffected: scope.DB().RowsAffected,
+		Error:        scope.DB().Error,
+	}
+
+	if started, ok := scope.InstanceGet("gorm_expect:spy_started"); ok {
+		execution.Duration = time.Since(started.(time.Time))
+	}
+
+	s.(*Spy).record(execution)
+}
@@ -0,0 +1,112 @@
+package gormexpect_test
+
+import (
+	"strings"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuted(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := User{Id: 1}
+	out := User{Id: 1, Name: "jinzhu"}
+
+	expect.First(&in).Returns(out)
+	db.First(&in)
+
+	// not expected, so sqlmock returns an error
+	var users []User
+	db.Where("name = ?", "itwx").Find(&users)
+
+	executed := expect.Executed()
+
+	assert.Equal(t, 2, len(executed))
+	assert.Equal(t, "query", executed[0].Kind)
+	assert.Equal(t, []interface{}{int64(1)}, executed[0].Args)
+	assert.Equal(t, int64(1), executed[0].RowsAffected)
+	assert.Nil(t, executed[0].Error)
+	assert.True(t, executed[0].Duration > 0)
+
+	var byName []expecter.Execution
+
+	for _, execution := range executed {
+		if strings.Contains(execution.SQL, "name = ?") {
+			byName = append(byName, execution)
+		}
+	}
+
+	assert.Equal(t, 1, len(byName))
+	assert.Equal(t, []interface{}{"itwx"}, byName[0].Args)
+	assert.NotNil(t, byName[0].Error)
+}
+
+func TestExecutedCallbacksOnly(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// none of these are expected, so they all fail, but only statements run
+	// through gorm's callbacks are recorded
+	db.Exec("UPDATE users SET name = ?", "jinzhu")
+	db.Begin()
+	db.Raw("SELECT * FROM users").Rows()
+
+	var users []User
+	db.Raw("SELECT * FROM users WHERE name = ?", "jinzhu").Scan(&users)
+
+	executed := expect.Executed()
+
+	if assert.Len(t, executed, 2) {
+		assert.Equal(t, "SELECT * FROM users", strings.TrimSpace(executed[0].SQL))
+		assert.Equal(t, "SELECT * FROM users WHERE name = ?", strings.TrimSpace(executed[1].SQL))
+		assert.NotNil(t, executed[1].Error)
+	}
+}
+
+func TestAssertMaxQueries(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := []User{User{Id: 1}, User{Id: 2}}
+	out := []User{
+		User{Id: 1, Emails: []Email{Email{Id: 1, UserId: 1}}},
+		User{Id: 2, Emails: []Email{Email{Id: 2, UserId: 2}}},
+	}
+
+	expect.Preload("Emails").Find(&users).Returns(out)
+	db.Preload("Emails").Find(&users)
+
+	assert.Nil(t, expect.AssertMaxQueries(2))
+	assert.Nil(t, expect.AssertMaxQueriesOn("emails", 1))
+
+	// N+1
+	for _, user := range users {
+		var emails []Email
+		db.Where("user_id = ?", user.Id).Find(&emails)
+	}
+
+	assert.NotNil(t, expect.AssertMaxQueries(2))
+	assert.Nil(t, expect.AssertMaxQueriesOn("users", 1))
+
+	err = expect.AssertMaxQueriesOn("emails", 1)
+
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "expected at most 1 queries on emails, but 3 were executed")
+		assert.Contains(t, err.Error(), "3. query: SELECT * FROM \"emails\"  WHERE (user_id = ?) [2]")
+	}
+}
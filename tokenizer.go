@@ -0,0 +1,161 @@
+package gormexpect
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenWord        tokenKind = iota // keywords and unquoted identifiers
+	tokenIdent                        // quoted identifiers, without their quotes
+	tokenString                       // string literals, without their quotes
+	tokenNumber                       // numeric literals
+	tokenPlaceholder                  // ?, $1 or gorm's $$$
+	tokenOperator                     // =, <>, <=, ||, * etc.
+	tokenPunct                        // ( ) [ ] , . ;
+)
+
+// token is a single SQL token. pos and end are byte offsets into the
+// tokenized statement, so the original text can be recovered.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	end  int
+}
+
+// is returns true if the token is the given keyword or punctuation
+func (t token) is(text string) bool {
+	switch t.kind {
+	case tokenWord:
+		return strings.EqualFold(t.text, text)
+	case tokenPunct, tokenOperator:
+		return t.text == text
+	default:
+		return false
+	}
+}
+
+// tokenize splits a SQL statement into tokens. It understands quoted
+// identifiers and string literals (including doubled quotes), numbers and
+// placeholders, which is all that is needed to find clauses and columns in
+// the statements gorm generates.
+func tokenize(sql string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '`' || c == '\'':
+			text, end, err := readQuoted(sql, i, c)
+
+			if err != nil {
+				return nil, err
+			}
+
+			kind := tokenIdent
+
+			if c == '\'' {
+				kind = tokenString
+			}
+
+			tokens = append(tokens, token{kind: kind, text: text, pos: i, end: end})
+			i = end
+		case c == '?':
+			tokens = append(tokens, token{kind: tokenPlaceholder, text: "?", pos: i, end: i + 1})
+			i++
+		case c == '$':
+			end := i + 1
+
+			if strings.HasPrefix(sql[i:], "$$$") {
+				end = i + 3
+			} else {
+				for end < len(sql) && isDigit(sql[end]) {
+					end++
+				}
+			}
+
+			if end == i+1 {
+				return nil, fmt.Errorf("Unexpected $ at %d in %s", i, sql)
+			}
+
+			tokens = append(tokens, token{kind: tokenPlaceholder, text: sql[i:end], pos: i, end: end})
+			i = end
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			end := i
+
+			for end < len(sql) && (isDigit(sql[end]) || sql[end] == '.') {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: sql[i:end], pos: i, end: end})
+			i = end
+		case isWordChar(c):
+			end := i
+
+			for end < len(sql) && (isWordChar(sql[end]) || isDigit(sql[end])) {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenWord, text: sql[i:end], pos: i, end: end})
+			i = end
+		// brackets are punctuation, as in ARRAY[1, 2] or tags[1], rather
+		// than the identifier quotes of SQL Server
+		case strings.IndexByte("()[],.;", c) >= 0:
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c), pos: i, end: i + 1})
+			i++
+		case strings.IndexByte("=<>!|+-*/%&^~:", c) >= 0:
+			end := i + 1
+
+			if end < len(sql) {
+				switch sql[i : end+1] {
+				case "<=", ">=", "<>", "!=", "||", "::":
+					end++
+				}
+			}
+
+			tokens = append(tokens, token{kind: tokenOperator, text: sql[i:end], pos: i, end: end})
+			i = end
+		default:
+			return nil, fmt.Errorf("Unexpected %q at %d in %s", c, i, sql)
+		}
+	}
+
+	return tokens, nil
+}
+
+// readQuoted reads a quoted token starting at sql[start]. A doubled closing
+// quote is an escaped quote.
+func readQuoted(sql string, start int, closing byte) (string, int, error) {
+	var text strings.Builder
+
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != closing {
+			text.WriteByte(sql[i])
+			continue
+		}
+
+		if i+1 < len(sql) && sql[i+1] == closing {
+			text.WriteByte(closing)
+			i++
+			continue
+		}
+
+		return text.String(), i + 1, nil
+	}
+
+	return "", 0, fmt.Errorf("Unterminated quote at %d in %s", start, sql)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
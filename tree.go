@@ -0,0 +1,411 @@
+package gormexpect
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// treeMarker starts statements printed from their parse tree. go-sqlmock
+// reports the pattern of an expectation that doesn't match, so the marker
+// tells the connector to retry with the executed statement's tree.
+const treeMarker = "/* gormexpect:tree */ "
+
+type treeKind int
+
+const (
+	treeToken    treeKind = iota // a single token
+	treeSequence                 // tokens and groups, in order
+	treeGroup                    // parentheses that aren't grouping a condition
+	treeList                     // a list whose items may be in any order
+	treeAnd
+	treeOr
+	treeNot
+)
+
+// treeNode is a node of the parse tree that QueryMatcherAST compares
+// statements by. Conditions are parsed into AND, OR and NOT nodes, so
+// parentheses only remain where they change the meaning of a condition.
+type treeNode struct {
+	kind     treeKind
+	token    token
+	children []*treeNode
+}
+
+func (n *treeNode) add(child *treeNode) {
+	n.children = append(n.children, child)
+}
+
+// addOperand adds an operand of an AND or OR, merging operands of the same
+// kind: (a AND b) AND c is the same condition as a AND b AND c.
+func (n *treeNode) addOperand(child *treeNode) {
+	if child.kind == n.kind {
+		n.children = append(n.children, child.children...)
+		return
+	}
+
+	n.add(child)
+}
+
+// hasPlaceholder returns true if any token under n is a placeholder
+func (n *treeNode) hasPlaceholder() bool {
+	if n.kind == treeToken {
+		return n.token.kind == tokenPlaceholder
+	}
+
+	for _, child := range n.children {
+		if child.hasPlaceholder() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String prints the tree in a canonical form, so that two statements have
+// the same tree if they print the same. Keywords and identifiers are
+// upper-cased, while literals are kept exactly.
+func (n *treeNode) String() string {
+	switch n.kind {
+	case treeToken:
+		return canonicalToken(n.token)
+	case treeGroup:
+		return "( " + n.children[0].String() + " )"
+	case treeList:
+		items := make([]string, len(n.children))
+		ordered := false
+
+		for i, child := range n.children {
+			items[i] = child.String()
+			ordered = ordered || child.hasPlaceholder()
+		}
+
+		// moving a placeholder would pair it with another arg
+		if !ordered {
+			sort.Strings(items)
+		}
+
+		return strings.Join(items, " , ")
+	case treeAnd, treeOr:
+		operator := " AND "
+
+		if n.kind == treeOr {
+			operator = " OR "
+		}
+
+		operands := make([]string, len(n.children))
+
+		for i, child := range n.children {
+			operands[i] = child.String()
+
+			if n.kind == treeAnd && child.kind == treeOr {
+				operands[i] = "( " + operands[i] + " )"
+			}
+		}
+
+		return strings.Join(operands, operator)
+	case treeNot:
+		operand := n.children[0].String()
+
+		if kind := n.children[0].kind; kind == treeAnd || kind == treeOr {
+			operand = "( " + operand + " )"
+		}
+
+		return "NOT " + operand
+	}
+
+	parts := make([]string, len(n.children))
+
+	for i, child := range n.children {
+		parts[i] = child.String()
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func canonicalToken(tok token) string {
+	switch tok.kind {
+	case tokenWord:
+		return strings.ToUpper(tok.text)
+	case tokenIdent:
+		return `"` + strings.Replace(strings.ToUpper(tok.text), `"`, `""`, -1) + `"`
+	case tokenString:
+		return "'" + strings.Replace(tok.text, "'", "''", -1) + "'"
+	}
+
+	return tok.text
+}
+
+// parseTree parses sql into the tree compared by QueryMatcherAST. Only the
+// parts of a statement whose form may vary are parsed: selected columns and
+// conditions. Anything else is kept as a sequence of tokens.
+func parseTree(sql string) (*treeNode, error) {
+	tokens, err := tokenize(sql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &treeParser{sql: sql, tokens: tokens}
+	tree := p.sequence()
+
+	if p.err == nil && !p.done() {
+		p.fail("Unbalanced ) at %d", p.peek().pos)
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return tree, nil
+}
+
+type treeParser struct {
+	sql    string
+	tokens []token
+	pos    int
+	err    error
+}
+
+func (p *treeParser) done() bool {
+	return p.err != nil || p.pos >= len(p.tokens)
+}
+
+func (p *treeParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *treeParser) next() *treeNode {
+	p.pos++
+
+	return &treeNode{kind: treeToken, token: p.tokens[p.pos-1]}
+}
+
+func (p *treeParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format+" in %s", append(args, p.sql)...)
+	}
+}
+
+// sequence parses tokens up to a closing parenthesis or the end
+func (p *treeParser) sequence() *treeNode {
+	seq := &treeNode{kind: treeSequence}
+
+	for !p.done() && !p.peek().is(")") {
+		tok := p.peek()
+
+		switch {
+		case tok.is("("):
+			seq.add(p.group())
+		case tok.is("SELECT"):
+			seq.add(p.next())
+
+			if !p.done() && (p.peek().is("DISTINCT") || p.peek().is("ALL")) {
+				seq.add(p.next())
+			}
+
+			seq.add(p.list())
+		case tok.is("WHERE") || tok.is("ON") || tok.is("HAVING"):
+			seq.add(p.next())
+			seq.add(p.or())
+		default:
+			seq.add(p.next())
+		}
+	}
+
+	return seq
+}
+
+// group parses parentheses around a subquery, function args or values
+func (p *treeParser) group() *treeNode {
+	open := p.peek()
+	p.pos++
+	inner := p.sequence()
+
+	if p.done() {
+		p.fail("Unclosed ( at %d", open.pos)
+	} else {
+		p.pos++
+	}
+
+	return &treeNode{kind: treeGroup, children: []*treeNode{inner}}
+}
+
+// list parses the columns of a SELECT, up to its FROM
+func (p *treeParser) list() *treeNode {
+	list := &treeNode{kind: treeList}
+
+	for {
+		item := &treeNode{kind: treeSequence}
+
+		for !p.done() && !p.peek().is(")") && !p.peek().is(",") && !p.peek().is("FROM") {
+			if p.peek().is("(") {
+				item.add(p.group())
+				continue
+			}
+
+			item.add(p.next())
+		}
+
+		list.add(item)
+
+		if p.done() || !p.peek().is(",") {
+			return list
+		}
+
+		p.pos++
+	}
+}
+
+func (p *treeParser) or() *treeNode {
+	node := &treeNode{kind: treeOr}
+	node.addOperand(p.and())
+
+	for !p.done() && p.peek().is("OR") {
+		p.pos++
+		node.addOperand(p.and())
+	}
+
+	if len(node.children) == 1 {
+		return node.children[0]
+	}
+
+	return node
+}
+
+func (p *treeParser) and() *treeNode {
+	node := &treeNode{kind: treeAnd}
+	node.addOperand(p.not())
+
+	for !p.done() && p.peek().is("AND") {
+		p.pos++
+		node.addOperand(p.not())
+	}
+
+	if len(node.children) == 1 {
+		return node.children[0]
+	}
+
+	return node
+}
+
+func (p *treeParser) not() *treeNode {
+	if !p.done() && p.peek().is("NOT") {
+		p.pos++
+
+		return &treeNode{kind: treeNot, children: []*treeNode{p.not()}}
+	}
+
+	if !p.done() && p.peek().is("(") && p.groupsCondition() {
+		open := p.peek()
+		p.pos++
+		node := p.or()
+
+		if p.done() || !p.peek().is(")") {
+			p.fail("Unclosed ( at %d", open.pos)
+			return node
+		}
+
+		p.pos++
+
+		return node
+	}
+
+	return p.operand()
+}
+
+// groupsCondition returns true if the parenthesis at the current token
+// groups a whole condition, rather than starting one, as in (a + b) > c
+func (p *treeParser) groupsCondition() bool {
+	closing := closingParen(p.tokens, p.pos)
+
+	if !p.tokens[closing].is(")") || p.tokens[p.pos+1].is("SELECT") {
+		return false
+	}
+
+	return closing+1 == len(p.tokens) || p.endsCondition(closing+1) || p.tokens[closing+1].is("AND") || p.tokens[closing+1].is("OR")
+}
+
+// operand parses a condition without AND, OR or NOT at its top level, such
+// as a comparison. The AND of a BETWEEN belongs to the operand.
+func (p *treeParser) operand() *treeNode {
+	node := &treeNode{kind: treeSequence}
+	between, cases := false, 0
+
+	for !p.done() {
+		tok := p.peek()
+
+		if cases == 0 && (p.endsCondition(p.pos) || tok.is("OR") || (tok.is("AND") && !between)) {
+			break
+		}
+
+		switch {
+		case tok.is("("):
+			node.add(p.group())
+			continue
+		case tok.is("BETWEEN"):
+			between = true
+		case tok.is("AND"):
+			between = false
+		case tok.is("CASE"):
+			cases++
+		case tok.is("END"):
+			cases--
+		}
+
+		node.add(p.next())
+	}
+
+	if len(node.children) == 0 && p.err == nil {
+		if p.done() {
+			p.fail("Missing condition at the end")
+		} else {
+			p.fail("Missing condition at %d", p.peek().pos)
+		}
+	}
+
+	return node
+}
+
+// endsCondition returns true if tokens[i] ends a WHERE, ON or HAVING
+// condition: a closing parenthesis, or the start of another clause. LEFT and
+// RIGHT are also function names when they are called.
+func (p *treeParser) endsCondition(i int) bool {
+	tok := p.tokens[i]
+
+	if tok.is(")") || tok.is(";") {
+		return true
+	}
+
+	if (tok.is("LEFT") || tok.is("RIGHT")) && i+1 < len(p.tokens) && p.tokens[i+1].is("(") {
+		return false
+	}
+
+	for _, keyword := range []string{"WHERE", "GROUP", "ORDER", "LIMIT", "OFFSET", "HAVING", "UNION", "INTERSECT", "EXCEPT", "RETURNING", "JOIN", "LEFT", "RIGHT", "INNER", "OUTER", "FULL", "CROSS", "FOR"} {
+		if tok.is(keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// treeStatement returns the executed statement query printed from its parse
+// tree, if err is go-sqlmock failing to match it against a QueryMatcherAST
+// pattern
+func treeStatement(err error, query string) (string, bool) {
+	pattern, ok := mismatchedPattern(err)
+
+	if !ok || !strings.HasPrefix(pattern, "^"+regexp.QuoteMeta(treeMarker)) {
+		return "", false
+	}
+
+	tree, err := parseTree(query)
+
+	if err != nil {
+		return "", false
+	}
+
+	return treeMarker + tree.String(), true
+}
@@ -0,0 +1,82 @@
+package gormexpect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestParseTree(t *testing.T) {
+	cases := map[string]string{
+		`select name, id from users where ((id = ?) and (name = ?))`:                  `SELECT ID , NAME FROM USERS WHERE ID = ? AND NAME = ?`,
+		`SELECT * FROM users WHERE (a = 1 OR b = 2) AND c = 3`:                        `SELECT * FROM USERS WHERE ( A = 1 OR B = 2 ) AND C = 3`,
+		`SELECT * FROM users WHERE a = 1 OR (b = 2 AND c = 3)`:                        `SELECT * FROM USERS WHERE A = 1 OR B = 2 AND C = 3`,
+		`SELECT * FROM users WHERE age BETWEEN ? AND ? AND name = 'Jinzhu'`:           `SELECT * FROM USERS WHERE AGE BETWEEN ? AND ? AND NAME = 'Jinzhu'`,
+		`SELECT * FROM users WHERE CASE WHEN a AND b THEN 1 END = 1 OR (c)`:           `SELECT * FROM USERS WHERE CASE WHEN A AND B THEN 1 END = 1 OR C`,
+		`SELECT * FROM users WHERE (age + 1) > 2 AND id IN (SELECT b, a FROM t)`:      `SELECT * FROM USERS WHERE ( AGE + 1 ) > 2 AND ID IN ( SELECT A , B FROM T )`,
+		`SELECT * FROM "users" WHERE left(name, 1) = 'j' ORDER BY "id" LIMIT 1`:       `SELECT * FROM "USERS" WHERE LEFT ( NAME , 1 ) = 'j' ORDER BY "ID" LIMIT 1`,
+		`SELECT concat(name, ?), id FROM users`:                                       `SELECT CONCAT ( NAME , ? ) , ID FROM USERS`,
+		`SELECT * FROM users JOIN emails ON (emails.user_id = users.id) WHERE (id=1)`: `SELECT * FROM USERS JOIN EMAILS ON EMAILS . USER_ID = USERS . ID WHERE ID = 1`,
+	}
+
+	for sql, expected := range cases {
+		tree, err := parseTree(sql)
+
+		if assert.Nil(t, err, sql) {
+			assert.Equal(t, expected, tree.String(), sql)
+		}
+	}
+}
+
+func TestParseTreeErrors(t *testing.T) {
+	for _, sql := range []string{
+		`SELECT * FROM users WHERE (id = ?`,
+		`SELECT * FROM users WHERE id = ?)`,
+		`SELECT count(* FROM users`,
+		`SELECT * FROM users WHERE id = ? AND`,
+		`SELECT * FROM users WHERE name = 'jinzhu`,
+	} {
+		_, err := parseTree(sql)
+
+		assert.NotNil(t, err, sql)
+	}
+}
+
+// TestMismatchedPattern fails if go-sqlmock changes the errors that
+// QueryMatcherAST depends on
+func TestMismatchedPattern(t *testing.T) {
+	pattern, _ := astPattern("SELECT * FROM users WHERE id = ?")
+
+	for _, kind := range []string{"exec", "query"} {
+		db, mock, err := sqlmock.New()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kind == "exec" {
+			mock.ExpectExec(pattern)
+			_, err = db.Exec("UPDATE users SET name = ? WHERE id IN ('[1]')", "jinzhu")
+		} else {
+			mock.ExpectQuery(pattern)
+			_, err = db.Query("SELECT * FROM users WHERE id IN ('[1]')")
+		}
+
+		mismatched, ok := mismatchedPattern(err)
+
+		assert.True(t, ok, kind)
+		assert.Equal(t, pattern, mismatched, kind)
+
+		tree, ok := treeStatement(err, "select * from users where (id = ?)")
+
+		assert.True(t, ok, kind)
+		assert.Regexp(t, pattern, tree, kind)
+
+		db.Close()
+	}
+
+	_, ok := mismatchedPattern(errors.New("all expectations were already fulfilled"))
+	assert.False(t, ok)
+}
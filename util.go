@@ -2,38 +2,16 @@ package gormexpect
 
 import (
 	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
 	"reflect"
-	"regexp"
 	"strings"
 	"unsafe"
 
 	"github.com/jinzhu/gorm"
 )
 
-func parseUpdateColumns(stmt string) ([]string, []string) {
-	re := regexp.MustCompile(`SET (.*)( ?:WHERE)?`)
-
-	match := re.FindAllStringSubmatch(stmt, -1)
-
-	if len(match) == 0 {
-		return nil, nil
-	}
-
-	index := re.FindAllStringSubmatchIndex(stmt, -1)
-
-	before := stmt[0:index[0][2]]
-	after := stmt[index[0][3]:]
-
-	colsString := match[0][1]
-	cols := strings.Split(colsString, ",")
-
-	for i, col := range cols {
-		cols[i] = strings.TrimSpace(col)
-	}
-
-	return []string{before, after}, cols
-}
-
 // indirect returns the actual value if the given value is a pointer
 func indirect(reflectValue reflect.Value) reflect.Value {
 	for reflectValue.Kind() == reflect.Ptr {
@@ -49,6 +27,7 @@ func indirect(reflectValue reflect.Value) reflect.Value {
 type Preload struct {
 	schema     string
 	conditions []interface{}
+	modelType  reflect.Type // the model loaded by the preload query
 }
 
 // getPreload copies preload from scope.Search, because it is a private field
@@ -68,7 +47,7 @@ func getPreload(scope *gorm.Scope) []Preload {
 			conditionsVal = reflect.NewAt(conditionsVal.Type(), unsafe.Pointer(conditionsVal.UnsafeAddr())).Elem()
 			conditions := (conditionsVal.Interface()).([]interface{})
 
-			preloadElem := Preload{schema, conditions}
+			preloadElem := Preload{schema: schema, conditions: conditions}
 			preload = append(preload, preloadElem)
 		}
 	}
@@ -76,39 +55,203 @@ func getPreload(scope *gorm.Scope) []Preload {
 	return preload
 }
 
+// expandPreload mirrors the order in which gorm:preload executes queries.
+// Nested schemas (e.g. "Orders.Items") produce one query for every level
+// that has not already been preloaded, and conditions only apply to the last
+// level of a schema.
+func expandPreload(modelType reflect.Type, preload []Preload) []Preload {
+	var expanded []Preload
+	preloaded := make(map[string]bool)
+
+	for _, p := range preload {
+		fieldNames := strings.Split(p.schema, ".")
+
+		for i := range fieldNames {
+			schema := strings.Join(fieldNames[:i+1], ".")
+
+			if preloaded[schema] {
+				continue
+			}
+
+			field, ok := getPreloadField(modelType, schema)
+
+			if !ok {
+				break
+			}
+
+			preloaded[schema] = true
+			elem := Preload{schema: schema, modelType: getElemType(field.Struct.Type)}
+
+			if i == len(fieldNames)-1 {
+				elem.conditions = p.conditions
+			}
+
+			expanded = append(expanded, elem)
+		}
+	}
+
+	return expanded
+}
+
+// getPreloadField walks a (possibly nested) preload schema from modelType and
+// returns the relationship field at the end of it
+func getPreloadField(modelType reflect.Type, schema string) (*gorm.StructField, bool) {
+	var field *gorm.StructField
+
+	for _, fieldName := range strings.Split(schema, ".") {
+		field = nil
+		modelStruct := (&gorm.Scope{Value: reflect.New(modelType).Interface()}).GetModelStruct()
+
+		for _, structField := range modelStruct.StructFields {
+			if structField.Name == fieldName && structField.Relationship != nil {
+				field = structField
+				break
+			}
+		}
+
+		if field == nil {
+			return nil, false
+		}
+
+		modelType = getElemType(field.Struct.Type)
+	}
+
+	return field, field != nil
+}
+
+// getElemType strips pointers and slices from a type, e.g. []*User becomes User
+func getElemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// isZero returns true if value holds the zero value of its type
+func isZero(value reflect.Value) bool {
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
+// copyOut returns a copy of the value pointed to by out. Pointers to
+// pointers are copied all the way down.
+func copyOut(out interface{}) interface{} {
+	outVal := reflect.ValueOf(out)
+
+	if outVal.Kind() != reflect.Ptr || outVal.IsNil() {
+		return out
+	}
+
+	copied := reflect.New(outVal.Elem().Type())
+
+	if outVal.Elem().Kind() == reflect.Ptr {
+		copied.Elem().Set(reflect.ValueOf(copyOut(outVal.Elem().Interface())))
+	} else {
+		copied.Elem().Set(outVal.Elem())
+	}
+
+	return copied.Interface()
+}
+
 // getRowForFields accepts a gorm.Field and converts them to []driver.Value so
-// that they can then be turned into sql.Rows
-func getRowForFields(fields []*gorm.Field) []driver.Value {
+// that they can then be turned into sql.Rows. Every normal field produces a
+// value, so rows always line up with their columns.
+func getRowForFields(fields []*gorm.Field) ([]driver.Value, error) {
 	var values []driver.Value
 	for _, field := range fields {
 		if field.IsNormal {
-			value := field.Field
+			value, err := getFieldValue(field.Field)
 
-			// dereference pointers
-			if field.Field.Kind() == reflect.Ptr {
-				value = reflect.Indirect(field.Field)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot convert field %s of type %s to a driver value: %s", field.Name, field.Field.Type(), err)
 			}
 
-			// check if we have a zero Value
-			// just append nil if it's not valid, so sqlmock won't complain
-			if !value.IsValid() {
-				values = append(values, nil)
-				continue
-			}
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
 
-			concreteVal := value.Interface()
+// getFieldValue converts a value to a driver.Value. Valuers (including those
+// with pointer receivers) are used first, then the conversions database/sql
+// applies to query args. Text marshalers such as UUIDs become strings, and
+// other composite types (maps, slices, structs) are encoded as JSON, which is
+// how they are usually stored.
+func getFieldValue(value reflect.Value) (driver.Value, error) {
+	// dereference pointers
+	for value.Kind() == reflect.Ptr {
+		value = reflect.Indirect(value)
+	}
 
-			if driver.IsValue(concreteVal) {
-				values = append(values, concreteVal)
-			} else if num, err := driver.DefaultParameterConverter.ConvertValue(concreteVal); err == nil {
-				values = append(values, num)
-			} else if valuer, ok := concreteVal.(driver.Valuer); ok {
-				if convertedValue, err := valuer.Value(); err == nil {
-					values = append(values, convertedValue)
-				}
-			}
+	// check if we have a zero Value
+	// just return nil if it's not valid, so sqlmock won't complain
+	if !value.IsValid() {
+		return nil, nil
+	}
+
+	concreteVal := value.Interface()
+
+	if driver.IsValue(concreteVal) {
+		return concreteVal, nil
+	}
+
+	if valuer, ok := asInterface(value, valuerType).(driver.Valuer); ok {
+		converted, err := valuer.Value()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return driver.DefaultParameterConverter.ConvertValue(converted)
+	}
+
+	if converted, err := driver.DefaultParameterConverter.ConvertValue(concreteVal); err == nil {
+		return converted, nil
+	}
+
+	if marshaler, ok := asInterface(value, textMarshalerType).(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return string(text), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			bytes := make([]byte, value.Len())
+			reflect.Copy(reflect.ValueOf(bytes), value)
+			return bytes, nil
 		}
+
+		return json.Marshal(concreteVal)
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		return json.Marshal(concreteVal)
+	}
+
+	return nil, fmt.Errorf("unsupported kind %s", value.Kind())
+}
+
+var (
+	valuerType        = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// asInterface returns value, or a pointer to it, as whichever implements
+// iface. Fields are addressable, so methods with pointer receivers are found
+// too. nil is returned if neither does.
+func asInterface(value reflect.Value, iface reflect.Type) interface{} {
+	if value.Type().Implements(iface) {
+		return value.Interface()
+	}
+
+	if value.CanAddr() && value.Addr().Type().Implements(iface) {
+		return value.Addr().Interface()
 	}
 
-	return values
+	return nil
 }
@@ -0,0 +1,33 @@
+package gormexpect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetList(t *testing.T) {
+	cases := map[string][]string{
+		`UPDATE "users" SET "age" = age + ? WHERE "id" IN (?,?)`:                         {`"age" = age + ?`},
+		`UPDATE "users" SET "tags" = ARRAY[?, ?], "name" = ? RETURNING "id", "name"`:     {`"tags" = ARRAY[?, ?]`, `"name" = ?`},
+		`UPDATE "users" SET "name" = concat(name, ', ', ?) FROM "emails" WHERE "id" = ?`: {`"name" = concat(name, ', ', ?)`},
+		`UPDATE "users" SET "name" = ?;`:                                                 {`"name" = ?`},
+		`UPDATE "users" WHERE "id" = ?`:                                                  nil,
+	}
+
+	for sql, expected := range cases {
+		tokens, err := tokenize(sql)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var items []string
+
+		for _, item := range setList(tokens) {
+			items = append(items, sql[tokens[item.start].pos:tokens[item.end-1].end])
+		}
+
+		assert.Equal(t, expected, items, sql)
+	}
+}
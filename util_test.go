@@ -0,0 +1,168 @@
+package gormexpect_test
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	expecter "github.com/iantanwx/gorm-expect"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+// DocumentID has no Valuer, only a text encoding
+type DocumentID [4]byte
+
+func (id DocumentID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(id[:])), nil
+}
+
+func (id *DocumentID) Scan(value interface{}) error {
+	text, ok := value.(string)
+
+	if !ok {
+		return fmt.Errorf("Cannot scan %T into DocumentID", value)
+	}
+
+	_, err := hex.Decode(id[:], []byte(text))
+	return err
+}
+
+type DocumentState string
+
+// Tags is stored as JSON, but only knows how to scan itself
+type Tags []string
+
+func (t *Tags) Scan(value interface{}) error {
+	return json.Unmarshal(value.([]byte), t)
+}
+
+// Metadata has a Valuer with a pointer receiver
+type Metadata map[string]string
+
+func (m *Metadata) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
+func (m *Metadata) Scan(value interface{}) error {
+	return json.Unmarshal(value.([]byte), m)
+}
+
+type Audit struct {
+	CreatedBy string
+	UpdatedBy string
+}
+
+type Document struct {
+	ID       DocumentID
+	State    DocumentState
+	Tags     Tags
+	Metadata Metadata
+	Audit    Audit `gorm:"embedded;embedded_prefix:audit_"`
+}
+
+type Checksum string
+
+func (c Checksum) Value() (driver.Value, error) {
+	return nil, errors.New("checksum not computed")
+}
+
+type Upload struct {
+	ID       int
+	Checksum Checksum
+}
+
+func TestReturnsCustomTypes(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := []Document{}
+	out := []Document{
+		Document{
+			ID:       DocumentID{1, 2, 3, 4},
+			State:    "draft",
+			Tags:     Tags{"go", "gorm"},
+			Metadata: Metadata{"lang": "en"},
+			Audit:    Audit{CreatedBy: "jinzhu", UpdatedBy: "itwx"},
+		},
+	}
+
+	expect.Find(&in).Returns(out)
+	db.Find(&in)
+
+	assert.Nil(t, expect.AssertExpectations())
+	assert.Equal(t, out, in)
+}
+
+func TestReturnsUnconvertibleField(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := Upload{}
+	message := "Cannot convert field Checksum of type gormexpect_test.Checksum to a driver value: checksum not computed"
+
+	expect.First(&in).Returns(Upload{ID: 1, Checksum: "abc"})
+	err = db.First(&in).Error
+
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), message)
+	}
+
+	if err := expect.AssertExpectations(); assert.NotNil(t, err) {
+		assert.Equal(t, message, err.Error())
+	}
+}
+
+func TestUpdateWhereIn(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := map[string]interface{}{"name": "jinzhu", "age": 18}
+
+	expect.Begin()
+	expect.Model(&User{}).Where("id IN (?)", []int{1, 2}).Updates(updates).WillSucceed(0, 2)
+	expect.Commit()
+	result := db.Model(&User{}).Where("id IN (?)", []int{1, 2}).Updates(updates)
+
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Nil(t, expect.AssertExpectations())
+}
+
+func TestUpdateExpr(t *testing.T) {
+	db, expect, err := expecter.NewDefaultExpecter()
+	defer db.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Id: 1}
+	updates := map[string]interface{}{
+		"age":  gorm.Expr("age + ?", 1),
+		"name": gorm.Expr("concat(name, ', jr')"),
+	}
+
+	expect.Begin()
+	expect.Model(&user).Updates(updates).WillSucceed(0, 1)
+	expect.Commit()
+	result := db.Model(&user).Updates(updates)
+
+	assert.Nil(t, result.Error)
+	assert.Nil(t, expect.AssertExpectations())
+}